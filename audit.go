@@ -0,0 +1,43 @@
+package cicada
+
+import "time"
+
+// Audited actions. Each names what was done, and its event's RoomId and
+// Target say to what.
+const (
+	AuditUserCreate     = "user.create"
+	AuditUserRename     = "user.rename"
+	AuditUserPassword   = "user.password"
+	AuditUserDelete     = "user.delete"
+	AuditRoomCreate     = "room.create"
+	AuditRoomUpdate     = "room.update"
+	AuditRoomJoin       = "room.join"
+	AuditRoomLeave      = "room.leave"
+	AuditRoomDelete     = "room.delete"
+	AuditRoomRole       = "room.role"
+	AuditRoomTransfer   = "room.transfer"
+	AuditRoomRetention  = "room.retention"
+	AuditRoomLegalHold  = "room.legal_hold"
+	AuditRoomPrune      = "room.prune"
+	AuditInviteCreate   = "invite.create"
+	AuditDirectOpen     = "direct.open"
+	AuditMessageEdit    = "message.edit"
+	AuditMessageDelete  = "message.delete"
+	AuditSanction       = "sanction.create"
+	AuditSanctionRevoke = "sanction.revoke"
+)
+
+// AuditEvent records a change made through the chat service. Changes maps
+// each changed field to its new value, and Source is the address the
+// request came from. Message text is never recorded, since the log outlives
+// deleted and pruned messages.
+type AuditEvent struct {
+	Id      string            `clover:"_id" json:"id"`
+	Date    time.Time         `clover:"date" json:"date"`
+	Actor   string            `clover:"actor" json:"actor"`
+	Action  string            `clover:"action" json:"action"`
+	RoomId  string            `clover:"roomId,omitempty" json:"roomId,omitempty"`
+	Target  string            `clover:"target,omitempty" json:"target,omitempty"`
+	Changes map[string]string `clover:"changes,omitempty" json:"changes,omitempty"`
+	Source  string            `clover:"source,omitempty" json:"source,omitempty"`
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"cicada"
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+const (
+	callerKey contextKey = iota
+)
+
+type credentials struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type session struct {
+	Token   string      `json:"token"`
+	Expires time.Time   `json:"expires"`
+	User    cicada.User `json:"user"`
+}
+
+// Login exchanges a user name and password for a session token.
+func (h *HttpHandler) Login(w http.ResponseWriter, r *http.Request) {
+	req := credentials{}
+	err := processJsonRequest(r, &req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	u, err := h.cs.Authenticate(req.Name, req.Password)
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+
+	token, expires, err := h.tokens.Issue(u.Id)
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, session{Token: token, Expires: expires, User: u})
+}
+
+// authenticated rejects requests without a valid session token and passes
+// the authenticated user id to next through the request context.
+func (h *HttpHandler) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := h.tokens.Verify(bearerToken(r))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cicada"`)
+			responseFromError(err, w)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), callerKey, userId)))
+	}
+}
+
+// caller returns the id of the authenticated user making the request.
+func caller(r *http.Request) string {
+	userId, _ := r.Context().Value(callerKey).(string)
+	return userId
+}
+
+// bearerToken reads the session token from the Authorization header. Browsers
+// cannot set headers on a websocket upgrade, so those may pass it as the
+// access_token query parameter instead.
+func bearerToken(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
@@ -4,42 +4,164 @@ import (
 	"bytes"
 	"cicada"
 	"cicada/internal/server"
+	"cicada/internal/server/auth"
+	"cicada/internal/server/store/audit"
+	"cicada/internal/server/store/chat"
 	"cicada/internal/server/store/image"
-	"context"
 	"encoding/json"
 	"errors"
+	"expvar"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"nhooyr.io/websocket"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
-type roomRequest struct {
-	RoomId string `json:"roomId"`
-	UserId string `json:"userId"`
+const (
+	// imageCacheControl lets images be cached for a year, the longest
+	// lifetime caches are expected to honour.
+	imageCacheControl = "public, max-age=31536000, immutable"
+	// uploadOverhead is how much of an upload may be spent on multipart
+	// headers, boundaries and other parts besides the image itself.
+	uploadOverhead = 64 << 10
+)
+
+type userPatch struct {
+	Name     *string `json:"name"`
+	Password *string `json:"password"`
 }
 
-type registerMessage struct {
+type roomPatch struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+type ownerRequest struct {
 	UserId string `json:"userId"`
 }
 
+// sanctionRequest asks for a moderation action. Duration is a Go duration
+// such as "30m", and is ignored for kicks.
+type sanctionRequest struct {
+	UserId   string `json:"userId"`
+	Action   string `json:"action"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+}
+
 type HttpHandler struct {
-	cs         *server.ChatService
-	imageStore *image.Store
+	cs           *server.ChatService
+	imageStore   *image.Store
+	tokens       *auth.Tokens
+	maxImageSize int64
 }
 
+// GetImage serves an image, or one of its renditions when a size is given.
+// Ids are hashes of the contents, so an image never changes and clients and
+// proxies may cache it indefinitely. Conditional and range requests are
+// handled by http.ServeContent.
 func (h *HttpHandler) GetImage(w http.ResponseWriter, r *http.Request) {
 	imageId := r.PathValue("id")
-	b, err := h.imageStore.Get(imageId)
+	size := r.URL.Query().Get("size")
+	etag := strconv.Quote(imageId)
+
+	switch size {
+	case "":
+	case cicada.RenditionThumb, cicada.RenditionMedium:
+		etag = strconv.Quote(imageId + "-" + size)
+	default:
+		http.Error(w, "unknown image size "+size, http.StatusBadRequest)
+		return
+	}
+
+	// copy the image out, so a slow client does not hold the read
+	// transaction open while it downloads
+	var contentType string
+	var content []byte
+	err := h.imageStore.View(imageId, size, func(img cicada.Image, b []byte) error {
+		contentType = img.ContentType
+		content = bytes.Clone(b)
+		return nil
+	})
+
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+		responseFromError(err, w)
+		return
 	}
 
-	_, err = io.Copy(w, bytes.NewReader(b))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", imageCacheControl)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+// UploadImage stores the image sent in the "image" part of a multipart form
+// and returns its metadata, so the id can be attached to a message. The whole
+// body is limited, so other parts cannot be used to send unbounded data.
+func (h *HttpHandler) UploadImage(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxImageSize+uploadOverhead)
+	reader, err := r.MultipartReader()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		http.Error(w, "expected a multipart form", http.StatusBadRequest)
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			http.Error(w, "missing image part", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			h.uploadFailed(w, err)
+			return
+		}
+
+		if part.FormName() != "image" {
+			part.Close()
+			continue
+		}
+
+		b, err := io.ReadAll(io.LimitReader(part, h.maxImageSize+1))
+		part.Close()
+		if err != nil {
+			h.uploadFailed(w, err)
+			return
+		}
+
+		if int64(len(b)) > h.maxImageSize {
+			responseFromError(fmt.Errorf("%w: images must be at most %d bytes", cicada.ErrorTooLarge, h.maxImageSize), w)
+			return
+		}
+
+		img, err := h.imageStore.Save(part.FileName(), b)
+		if err != nil {
+			responseFromError(err, w)
+			return
+		}
+		writeJsonResponse(w, http.StatusCreated, img)
+		return
 	}
-	w.WriteHeader(http.StatusOK)
+}
+
+// uploadFailed answers an upload that could not be read, either because it
+// is too large or because the form is malformed.
+func (h *HttpHandler) uploadFailed(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		responseFromError(fmt.Errorf("%w: images must be at most %d bytes", cicada.ErrorTooLarge, h.maxImageSize), w)
+		return
+	}
+	http.Error(w, "malformed multipart form", http.StatusBadRequest)
 }
 
 func (h *HttpHandler) CreateRoom(w http.ResponseWriter, r *http.Request) {
@@ -49,78 +171,435 @@ func (h *HttpHandler) CreateRoom(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	newRoom, err := h.cs.CreateRoom(room)
-	writeJsonResponse(w, newRoom)
-}
 
-func (h *HttpHandler) Room(w http.ResponseWriter, r *http.Request) {
-	roomReq := roomRequest{}
-	err := processJsonRequest(r, &roomReq)
+	newRoom, err := h.cs.CreateRoom(r.Context(), caller(r), room)
 	if err != nil {
 		responseFromError(err, w)
 		return
 	}
+	writeJsonResponse(w, http.StatusOK, newRoom)
+}
+
+func (h *HttpHandler) Room(w http.ResponseWriter, r *http.Request) {
+	roomId := r.PathValue("id")
+	userId := caller(r)
 
 	action := r.URL.Query().Get("a")
-	if len(action) == 0 {
+	switch action {
+	case "join":
+		chatLog, err := h.cs.JoinRoom(r.Context(), userId, roomId, r.URL.Query().Get("invite"))
+		if err != nil {
+			responseFromError(err, w)
+			return
+		}
+		writeJsonResponse(w, http.StatusOK, chatLog)
+	case "leave":
+		err := h.cs.LeaveRoom(r.Context(), userId, roomId)
+		if err != nil {
+			responseFromError(err, w)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
 		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// UpdateRoom changes a room's name or description. Fields left out of the
+// request are not changed.
+func (h *HttpHandler) UpdateRoom(w http.ResponseWriter, r *http.Request) {
+	patch := roomPatch{}
+	if err := processJsonRequest(r, &patch); err != nil {
+		http.Error(w, "malformed request", http.StatusBadRequest)
+		return
+	}
+
+	room, err := h.cs.UpdateRoom(r.Context(), caller(r), r.PathValue("id"), patch.Name, patch.Description)
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, room)
+}
+
+// SetRole makes a member of a room a moderator or a plain member.
+func (h *HttpHandler) SetRole(w http.ResponseWriter, r *http.Request) {
+	req := roleRequest{}
+	if err := processJsonRequest(r, &req); err != nil {
+		http.Error(w, "malformed request", http.StatusBadRequest)
+		return
+	}
+
+	room, err := h.cs.SetRole(r.Context(), caller(r), r.PathValue("id"), r.PathValue("userId"), req.Role)
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, room)
+}
+
+// TransferOwnership hands the caller's room to another member.
+func (h *HttpHandler) TransferOwnership(w http.ResponseWriter, r *http.Request) {
+	req := ownerRequest{}
+	if err := processJsonRequest(r, &req); err != nil {
+		http.Error(w, "malformed request", http.StatusBadRequest)
+		return
+	}
+
+	room, err := h.cs.TransferOwnership(r.Context(), caller(r), r.PathValue("id"), req.UserId)
+	if err != nil {
+		responseFromError(err, w)
 		return
 	}
+	writeJsonResponse(w, http.StatusOK, room)
+}
 
-	if action == "join" {
-		err = h.cs.LeaveRoom(roomReq.UserId, roomReq.RoomId)
-	} else if action == "roomReq" {
-		chatLog, err := h.cs.JoinRoom(roomReq.UserId, roomReq.RoomId)
-		if err == nil {
-			writeJsonResponse(w, chatLog)
+// SetRetention sets how long a room's messages are kept, such as
+// {"maxAge": "720h", "maxMessages": 10000}.
+func (h *HttpHandler) SetRetention(w http.ResponseWriter, r *http.Request) {
+	p := cicada.Retention{}
+	if err := processJsonRequest(r, &p); err != nil {
+		http.Error(w, "malformed request", http.StatusBadRequest)
+		return
+	}
+
+	room, err := h.cs.SetRetention(r.Context(), caller(r), r.PathValue("id"), p)
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, room)
+}
+
+// LegalHold places a room under legal hold with PUT, or releases it with
+// DELETE.
+func (h *HttpHandler) LegalHold(w http.ResponseWriter, r *http.Request) {
+	room, err := h.cs.SetLegalHold(r.Context(), caller(r), r.PathValue("id"), r.Method == http.MethodPut)
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, room)
+}
+
+// Sanction kicks, bans or mutes a member of a room.
+func (h *HttpHandler) Sanction(w http.ResponseWriter, r *http.Request) {
+	req := sanctionRequest{}
+	if err := processJsonRequest(r, &req); err != nil {
+		http.Error(w, "malformed request", http.StatusBadRequest)
+		return
+	}
+
+	var d time.Duration
+	if len(req.Duration) != 0 {
+		var err error
+		if d, err = time.ParseDuration(req.Duration); err != nil {
+			http.Error(w, "duration must be a duration such as 30m", http.StatusBadRequest)
+			return
 		}
-	} else {
-		if len(action) == 0 {
-			w.WriteHeader(http.StatusBadRequest)
+	}
+
+	userId, roomId := caller(r), r.PathValue("id")
+	var sa cicada.Sanction
+	var err error
+	switch req.Action {
+	case cicada.SanctionKick:
+		sa, err = h.cs.Kick(r.Context(), userId, roomId, req.UserId, req.Reason)
+	case cicada.SanctionBan:
+		sa, err = h.cs.Ban(r.Context(), userId, roomId, req.UserId, req.Reason, d)
+	case cicada.SanctionMute:
+		sa, err = h.cs.Mute(r.Context(), userId, roomId, req.UserId, req.Reason, d)
+	default:
+		http.Error(w, "action must be kick, ban or mute", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusCreated, sa)
+}
+
+// Sanctions lists the moderation actions taken in a room.
+func (h *HttpHandler) Sanctions(w http.ResponseWriter, r *http.Request) {
+	list, err := h.cs.Sanctions(caller(r), r.PathValue("id"))
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, list)
+}
+
+// RevokeSanction undoes a moderation action and returns its record.
+func (h *HttpHandler) RevokeSanction(w http.ResponseWriter, r *http.Request) {
+	sa, err := h.cs.Revoke(r.Context(), caller(r), r.PathValue("id"), r.PathValue("sanctionId"))
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, sa)
+}
+
+// CreateInvite issues an invite to a room the caller belongs to.
+func (h *HttpHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	inv, err := h.cs.CreateInvite(r.Context(), caller(r), r.PathValue("id"))
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusCreated, inv)
+}
+
+// PublicRooms pages through the room directory. The q parameter searches
+// room names and descriptions, and from is the next offset returned with the
+// previous page.
+func (h *HttpHandler) PublicRooms(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	var values [2]int
+	for i, name := range []string{"from", "limit"} {
+		if params.Has(name) {
+			var err error
+			values[i], err = strconv.Atoi(params.Get(name))
+			if err != nil || values[i] < 0 {
+				http.Error(w, name+" must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	page, err := h.cs.PublicRooms(params.Get("q"), values[0], values[1])
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, page)
+}
+
+// OpenDirect returns the caller's direct conversation with another user,
+// creating it the first time.
+func (h *HttpHandler) OpenDirect(w http.ResponseWriter, r *http.Request) {
+	room, err := h.cs.OpenDirect(r.Context(), caller(r), r.PathValue("userId"))
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, room)
+}
+
+// History pages through a room's messages. At most one of the before, after
+// or around parameters may be given, as a cursor or a message id; without one
+// the newest messages are returned. Messages are newest first unless order=asc.
+func (h *HttpHandler) History(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	anchor := server.Latest
+	position := ""
+	for name, a := range map[string]server.Anchor{"before": server.Before, "after": server.After, "around": server.Around} {
+		if params.Has(name) {
+			if anchor != server.Latest {
+				http.Error(w, "only one of before, after or around may be given", http.StatusBadRequest)
+				return
+			}
+			anchor = a
+			position = params.Get(name)
+		}
+	}
+
+	limit := 0
+	if params.Has("limit") {
+		var err error
+		limit, err = strconv.Atoi(params.Get("limit"))
+		if err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
 			return
 		}
 	}
 
+	order := params.Get("order")
+	if order != "" && order != "asc" && order != "desc" {
+		http.Error(w, "order must be asc or desc", http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.cs.History(caller(r), r.PathValue("id"), anchor, position, limit)
 	if err != nil {
 		responseFromError(err, w)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+
+	if order == "asc" {
+		slices.Reverse(page.Messages)
+	}
+	writeJsonResponse(w, http.StatusOK, page)
 }
 
-func (h *HttpHandler) Connect(w http.ResponseWriter, r *http.Request) {
-	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		Subprotocols: []string{"cicada_v1"},
-	})
+// MarkRead moves the caller's read marker in a room. The body may name the
+// message read up to, otherwise the room is read up to its newest message.
+func (h *HttpHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	req := cicada.ReadPayload{}
+	if r.ContentLength != 0 {
+		if err := processJsonRequest(r, &req); err != nil {
+			http.Error(w, "malformed request", http.StatusBadRequest)
+			return
+		}
+	}
 
+	receipt, err := h.cs.MarkRead(caller(r), r.PathValue("id"), req.MessageId)
 	if err != nil {
-		slog.Error("accept", "error", err)
+		responseFromError(err, w)
 		return
 	}
+	writeJsonResponse(w, http.StatusOK, receipt)
+}
+
+// Rooms lists the caller's rooms with their unread counts.
+func (h *HttpHandler) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := h.cs.Rooms(caller(r))
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, rooms)
+}
+
+// RoomPresence lists which members of a room are connected.
+func (h *HttpHandler) RoomPresence(w http.ResponseWriter, r *http.Request) {
+	presence, err := h.cs.RoomPresence(caller(r), r.PathValue("id"))
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, presence)
+}
 
-	mtype, bytes, err := c.Read(context.Background())
-	if mtype != websocket.MessageText {
+func (h *HttpHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	req := credentials{}
+	err := processJsonRequest(r, &req)
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	register := registerMessage{}
-	err = json.Unmarshal(bytes, &register)
+	newUser, err := h.cs.CreateUser(r.Context(), cicada.User{Name: req.Name}, req.Password)
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusCreated, newUser)
+}
+
+// GetUser returns a user's profile. The rooms they are in, which include
+// private rooms and direct conversations, are only shown to the user
+// themselves and to administrators.
+func (h *HttpHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	u, err := h.cs.GetUser(r.PathValue("id"))
 	if err != nil {
-		slog.Error("error decoding registration", "error", err)
+		responseFromError(err, w)
 		return
 	}
 
-	h.cs.Connect(register.UserId, c)
-	slog.Info("new connection", "user", register.UserId)
+	if userId := caller(r); userId != u.Id && !h.cs.IsAdmin(userId) {
+		u.Rooms = nil
+	}
+	writeJsonResponse(w, http.StatusOK, u)
 }
 
-func (h *HttpHandler) Disconnect(w http.ResponseWriter, r *http.Request) {
-	mesg := registerMessage{}
-	err := processJsonRequest(r, &mesg)
+func (h *HttpHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	userId := r.PathValue("id")
+	if userId != caller(r) {
+		responseFromError(fmt.Errorf("%w: users can only change their own account", cicada.ErrorForbidden), w)
+		return
+	}
+
+	patch := userPatch{}
+	err := processJsonRequest(r, &patch)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if patch.Password != nil {
+		err = h.cs.SetPassword(r.Context(), userId, *patch.Password)
+		if err != nil {
+			responseFromError(err, w)
+			return
+		}
+	}
+
+	var u cicada.User
+	if patch.Name != nil {
+		u, err = h.cs.RenameUser(r.Context(), userId, *patch.Name)
+	} else {
+		u, err = h.cs.GetUser(userId)
+	}
+
+	if err != nil {
+		responseFromError(err, w)
+		return
 	}
+	writeJsonResponse(w, http.StatusOK, u)
+}
+
+func (h *HttpHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	userId := r.PathValue("id")
+	if userId != caller(r) {
+		responseFromError(fmt.Errorf("%w: users can only delete their own account", cicada.ErrorForbidden), w)
+		return
+	}
+
+	err := h.cs.DeleteUser(r.Context(), userId)
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Connect upgrades the request to a websocket. A client that reconnects
+// passes the last message it saw, or the cursor of a replay.end frame, as
+// since to be sent what it missed.
+func (h *HttpHandler) Connect(w http.ResponseWriter, r *http.Request) {
+	userId := caller(r)
+	if _, err := h.cs.GetUser(userId); err != nil {
+		responseFromError(err, w)
+		return
+	}
+
+	var since chat.Cursor
+	if position := r.URL.Query().Get("since"); len(position) != 0 {
+		c, err := h.cs.ReplayCursor(position)
+		if err != nil {
+			responseFromError(err, w)
+			return
+		}
+		since = c
+	}
+
+	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		Subprotocols: []string{"cicada_v1"},
+	})
+
+	if err != nil {
+		slog.Error("accept", "error", err)
+		return
+	}
+
+	err = h.cs.Connect(r.Context(), userId, since, c)
+	if err != nil {
+		slog.Error("unable to register connection", "user", userId, "error", err)
+		c.Close(websocket.StatusPolicyViolation, "unknown user")
+		return
+	}
+	slog.Info("new connection", "user", userId)
+}
+
+func (h *HttpHandler) Disconnect(w http.ResponseWriter, r *http.Request) {
+	err := h.cs.Disconnect(caller(r))
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 }
 
 func (h *HttpHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
@@ -130,6 +609,169 @@ func (h *HttpHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+
+	mesg.Sender = caller(r)
+	stored, err := h.cs.SendMessage(mesg)
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusCreated, stored)
+}
+
+// EditMessage replaces the text of one of the caller's messages.
+func (h *HttpHandler) EditMessage(w http.ResponseWriter, r *http.Request) {
+	req := cicada.EditPayload{}
+	if err := processJsonRequest(r, &req); err != nil {
+		http.Error(w, "malformed request", http.StatusBadRequest)
+		return
+	}
+
+	m, err := h.cs.EditMessage(r.Context(), caller(r), r.PathValue("id"), req.Text)
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, m)
+}
+
+// DeleteMessage replaces a message with a tombstone and returns it.
+func (h *HttpHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	m, err := h.cs.DeleteMessage(r.Context(), caller(r), r.PathValue("id"))
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, m)
+}
+
+// Revisions lists the earlier texts of an edited message.
+func (h *HttpHandler) Revisions(w http.ResponseWriter, r *http.Request) {
+	revisions, err := h.cs.Revisions(caller(r), r.PathValue("id"))
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, revisions)
+}
+
+// Thread pages through the replies to a message, oldest first. The after
+// parameter is the cursor returned with the previous page.
+func (h *HttpHandler) Thread(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	limit := 0
+	if params.Has("limit") {
+		var err error
+		limit, err = strconv.Atoi(params.Get("limit"))
+		if err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := h.cs.Thread(caller(r), r.PathValue("id"), params.Get("after"), limit)
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, page)
+}
+
+// Search finds messages in the caller's rooms. The q parameter holds the
+// words to look for, with phrases in double quotes. It can be narrowed with
+// from, a sender's user id, and after and before, as RFC 3339 times.
+func (h *HttpHandler) Search(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	if len(strings.TrimSpace(params.Get("q"))) == 0 {
+		http.Error(w, "q must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	var dates [2]time.Time
+	for i, name := range []string{"after", "before"} {
+		if params.Has(name) {
+			var err error
+			dates[i], err = time.Parse(time.RFC3339, params.Get(name))
+			if err != nil {
+				http.Error(w, name+" must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	limit := 0
+	if params.Has("limit") {
+		var err error
+		limit, err = strconv.Atoi(params.Get("limit"))
+		if err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := h.cs.Search(caller(r), params.Get("q"), params.Get("from"), dates[0], dates[1], limit)
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, results)
+}
+
+// AuditLog queries the audit log for administrators. The actor and room
+// parameters select events by who made them and where, and from and to bound
+// their date as RFC 3339 times. Events are newest first.
+func (h *HttpHandler) AuditLog(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	var dates [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		if params.Has(name) {
+			var err error
+			dates[i], err = time.Parse(time.RFC3339, params.Get(name))
+			if err != nil {
+				http.Error(w, name+" must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	limit := 0
+	if params.Has("limit") {
+		var err error
+		limit, err = strconv.Atoi(params.Get("limit"))
+		if err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	q := audit.Query{Actor: params.Get("actor"), RoomId: params.Get("room"), From: dates[0], To: dates[1], Limit: limit}
+	events, err := h.cs.AuditLog(caller(r), q)
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, events)
+}
+
+// Metrics serves the published expvar variables, such as the depth of the
+// client queues, to administrators.
+func (h *HttpHandler) Metrics(w http.ResponseWriter, r *http.Request) {
+	if !h.cs.IsAdmin(caller(r)) {
+		responseFromError(fmt.Errorf("%w: only administrators may read metrics", cicada.ErrorForbidden), w)
+		return
+	}
+	expvar.Handler().ServeHTTP(w, r)
+}
+
+// React adds the caller's reaction to a message with PUT, or removes it
+// with DELETE. The emoji is the last, escaped, path segment.
+func (h *HttpHandler) React(w http.ResponseWriter, r *http.Request) {
+	update, err := h.cs.React(caller(r), r.PathValue("id"), r.PathValue("emoji"), r.Method == http.MethodPut)
+	if err != nil {
+		responseFromError(err, w)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, update)
 }
 
 func processJsonRequest[E any](r *http.Request, value *E) error {
@@ -142,16 +784,17 @@ func processJsonRequest[E any](r *http.Request, value *E) error {
 	return err
 }
 
-func writeJsonResponse(w http.ResponseWriter, value interface{}) {
+func writeJsonResponse(w http.ResponseWriter, status int, value interface{}) {
 	bytes, err := json.Marshal(value)
 	if err != nil {
-		w.WriteHeader(500)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
+	w.WriteHeader(status)
 	_, err = w.Write(bytes)
 	if err != nil {
-		slog.Error("failed to write json response", err)
+		slog.Error("failed to write json response", "error", err)
 	}
 }
 
@@ -160,8 +803,23 @@ func responseFromError(e error, w http.ResponseWriter) {
 	if errors.Is(e, cicada.ErrorNotFound) {
 		code = http.StatusNotFound
 	} else if errors.Is(e, cicada.ErrorBadRequest) {
-		code = http.StatusNotFound
+		code = http.StatusBadRequest
+	} else if errors.Is(e, cicada.ErrorForbidden) {
+		code = http.StatusForbidden
+	} else if errors.Is(e, cicada.ErrorUnauthorized) {
+		code = http.StatusUnauthorized
+	} else if errors.Is(e, cicada.ErrorConflict) {
+		code = http.StatusConflict
+	} else if errors.Is(e, cicada.ErrorTooLarge) {
+		code = http.StatusRequestEntityTooLarge
+	} else if errors.Is(e, cicada.ErrorUnsupportedMedia) {
+		code = http.StatusUnsupportedMediaType
 	}
 
-	w.WriteHeader(code)
+	if code == http.StatusInternalServerError {
+		slog.Error("request failed", "error", e)
+		w.WriteHeader(code)
+		return
+	}
+	http.Error(w, e.Error(), code)
 }
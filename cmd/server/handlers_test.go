@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"cicada"
+	"cicada/internal/server"
+	"cicada/internal/server/auth"
+	"cicada/internal/server/store/audit"
+	"cicada/internal/server/store/chat"
+	"cicada/internal/server/store/image"
+	"cicada/internal/server/store/room"
+	"cicada/internal/server/store/search"
+	"cicada/internal/server/store/user"
+	"context"
+	"encoding/json"
+	badger "github.com/dgraph-io/badger/v4"
+	clover "github.com/ostafen/clover/v2"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testImageSize is the largest image the handler under test accepts.
+const testImageSize = 1024
+
+// handler serves the http api from databases in a fresh temporary directory.
+// The returned function closes the databases and removes the directory.
+func handler() (*HttpHandler, func()) {
+	dir, err := os.MkdirTemp("", "cicada-http")
+	if err != nil {
+		log.Fatal("unable to create temp dir", err)
+	}
+
+	objDb, err := clover.Open(dir)
+	if err != nil {
+		log.Fatal("unable to open clover database", err)
+	}
+	kvDb, err := badger.Open(badger.DefaultOptions(filepath.Join(dir, "images")).WithLogger(nil))
+	if err != nil {
+		log.Fatal("unable to open image database", err)
+	}
+	searchDb, err := badger.Open(badger.DefaultOptions(filepath.Join(dir, "search")).WithLogger(nil))
+	if err != nil {
+		log.Fatal("unable to open search database", err)
+	}
+
+	quit := make(chan interface{})
+	imageStore := image.NewStore(kvDb)
+	cs := server.New(quit, server.DefaultOptions(), chat.NewStore(objDb), room.NewStore(objDb), user.NewStore(objDb), imageStore, search.NewStore(searchDb), audit.NewStore(objDb))
+	h := &HttpHandler{cs, imageStore, auth.NewTokens([]byte("test key"), time.Hour), testImageSize}
+	return h, func() {
+		close(quit)
+		searchDb.Close()
+		kvDb.Close()
+		objDb.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// login creates a user and returns a session token for them.
+func login(t *testing.T, h *HttpHandler, name string) (string, string) {
+	t.Helper()
+	u, err := h.cs.CreateUser(context.Background(), cicada.User{Name: name}, "password1")
+	if err != nil {
+		t.Fatal("unable to create user", err)
+	}
+	token, _, err := h.tokens.Issue(u.Id)
+	if err != nil {
+		t.Fatal("unable to issue token", err)
+	}
+	return u.Id, token
+}
+
+// serve sends a request through the routes, authenticated with token unless
+// it is empty.
+func serve(h *HttpHandler, token string, r *http.Request) *httptest.ResponseRecorder {
+	if len(token) != 0 {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.routes().ServeHTTP(w, r)
+	return w
+}
+
+func TestUnauthenticated(t *testing.T) {
+	h, done := handler()
+	defer done()
+
+	_, token := login(t, h, "owner")
+	tests := []struct {
+		method string
+		path   string
+		token  string
+	}{
+		{"POST", "/message", ""},
+		{"POST", "/message", "not a token"},
+		{"POST", "/message", token + "x"},
+		{"POST", "/image", ""},
+		{"GET", "/image/" + strings.Repeat("0", 64), ""},
+		{"GET", "/rooms", ""},
+	}
+
+	for _, tt := range tests {
+		w := serve(h, tt.token, httptest.NewRequest(tt.method, tt.path, strings.NewReader("{}")))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s with token %q: expected 401, got %d", tt.method, tt.path, tt.token, w.Code)
+		}
+		if len(w.Header().Get("WWW-Authenticate")) == 0 {
+			t.Errorf("%s %s: expected a WWW-Authenticate header", tt.method, tt.path)
+		}
+	}
+}
+
+func TestPostMessage(t *testing.T) {
+	h, done := handler()
+	defer done()
+
+	owner, token := login(t, h, "owner")
+	_, outsider := login(t, h, "outsider")
+	r, err := h.cs.CreateRoom(context.Background(), owner, cicada.Room{Name: "Lobby"})
+	if err != nil {
+		t.Fatal("unable to create room", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		body  string
+		code  int
+	}{
+		{"sent", token, `{"roomId":"` + r.Id + `","text":"hi"}`, http.StatusCreated},
+		{"malformed", token, `{"roomId":`, http.StatusBadRequest},
+		{"empty", token, `{"roomId":"` + r.Id + `","text":" "}`, http.StatusBadRequest},
+		{"missing room", token, `{"roomId":"nowhere","text":"hi"}`, http.StatusNotFound},
+		{"not a member", outsider, `{"roomId":"` + r.Id + `","text":"hi"}`, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		w := serve(h, tt.token, httptest.NewRequest("POST", "/message", strings.NewReader(tt.body)))
+		if w.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d %s", tt.name, tt.code, w.Code, w.Body)
+		}
+	}
+
+	// the sender is the caller, whatever the body claims
+	body := `{"roomId":"` + r.Id + `","sender":"someone","id":"mine","text":"hi"}`
+	w := serve(h, token, httptest.NewRequest("POST", "/message", strings.NewReader(body)))
+	m := cicada.ChatMessage{}
+	if b, _ := io.ReadAll(w.Body); json.Unmarshal(b, &m) != nil {
+		t.Fatal("unable to decode message", w.Code)
+	}
+	if m.Sender != owner || m.Id == "mine" {
+		t.Error("expected the server to assign the sender and id, got", m)
+	}
+}
+
+// pngBytes is enough of a PNG for its content type to be detected.
+var pngBytes = append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), make([]byte, 100)...)
+
+// part is one part of a multipart form.
+type part struct {
+	name string
+	body []byte
+}
+
+func uploadRequest(parts ...part) *http.Request {
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	for _, p := range parts {
+		fw, err := mw.CreateFormFile(p.name, p.name+".bin")
+		if err == nil {
+			_, err = fw.Write(p.body)
+		}
+		if err != nil {
+			log.Fatal("unable to write form", err)
+		}
+	}
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/image", buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestUploadImage(t *testing.T) {
+	h, done := handler()
+	defer done()
+
+	_, token := login(t, h, "owner")
+	notMultipart := httptest.NewRequest("POST", "/image", bytes.NewReader(pngBytes))
+	notMultipart.Header.Set("Content-Type", "image/png")
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		code int
+	}{
+		{"png", uploadRequest(part{"image", pngBytes}), http.StatusCreated},
+		{"too large", uploadRequest(part{"image", append(pngBytes, make([]byte, testImageSize)...)}), http.StatusRequestEntityTooLarge},
+		{"large other part", uploadRequest(part{"junk", make([]byte, testImageSize+uploadOverhead)}, part{"image", pngBytes}), http.StatusRequestEntityTooLarge},
+		{"text", uploadRequest(part{"image", []byte("just some text, not an image")}), http.StatusUnsupportedMediaType},
+		{"html", uploadRequest(part{"image", []byte("<html><script>alert(1)</script></html>")}), http.StatusUnsupportedMediaType},
+		{"no image part", uploadRequest(part{"file", pngBytes}), http.StatusBadRequest},
+		{"not multipart", notMultipart, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		w := serve(h, token, tt.r)
+		if w.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d %s", tt.name, tt.code, w.Code, w.Body)
+		}
+	}
+}
+
+func TestGetImage(t *testing.T) {
+	h, done := handler()
+	defer done()
+
+	_, token := login(t, h, "owner")
+	img, err := h.imageStore.Save("pixel.png", pngBytes)
+	if err != nil {
+		t.Fatal("unable to save image", err)
+	}
+	path := "/image/" + img.Id
+	etag := `"` + img.Id + `"`
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		value  string
+		code   int
+		body   []byte
+	}{
+		{"full", path, "", "", http.StatusOK, pngBytes},
+		{"not modified", path, "If-None-Match", etag, http.StatusNotModified, nil},
+		{"changed etag", path, "If-None-Match", `"other"`, http.StatusOK, pngBytes},
+		{"range", path, "Range", "bytes=0-3", http.StatusPartialContent, pngBytes[:4]},
+		{"range beyond the end", path, "Range", "bytes=1000-", http.StatusRequestedRangeNotSatisfiable, nil},
+		{"small image without thumbnail", path + "?size=thumb", "", "", http.StatusOK, pngBytes},
+		{"unknown size", path + "?size=huge", "", "", http.StatusBadRequest, nil},
+		{"missing", "/image/" + strings.Repeat("0", 64), "", "", http.StatusNotFound, nil},
+		{"invalid id", "/image/meta:" + img.Id, "", "", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if len(tt.header) != 0 {
+			r.Header.Set(tt.header, tt.value)
+		}
+		w := serve(h, token, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d %s", tt.name, tt.code, w.Code, w.Body)
+			continue
+		}
+		if tt.body != nil && !bytes.Equal(w.Body.Bytes(), tt.body) {
+			t.Errorf("%s: expected %d bytes of the image, got %d", tt.name, len(tt.body), w.Body.Len())
+		}
+	}
+
+	w := serve(h, token, httptest.NewRequest("GET", path, nil))
+	if got := w.Header().Get("ETag"); got != etag {
+		t.Errorf("expected etag %s, got %s", etag, got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/png" {
+		t.Error("expected the stored content type, got", got)
+	}
+	if got := w.Header().Get("Cache-Control"); got != imageCacheControl {
+		t.Error("expected images to be cacheable, got", got)
+	}
+	if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Error("expected range support to be advertised, got", got)
+	}
+
+	w = serve(h, token, httptest.NewRequest("GET", path+"?size=thumb", nil))
+	if got := w.Header().Get("ETag"); got != `"`+img.Id+`-thumb"` {
+		t.Error("expected renditions to have their own etag, got", got)
+	}
+}
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"cicada/internal/config"
 	"cicada/internal/server"
+	"cicada/internal/server/auth"
+	"cicada/internal/server/store/audit"
 	"cicada/internal/server/store/chat"
 	"cicada/internal/server/store/image"
 	"cicada/internal/server/store/room"
+	"cicada/internal/server/store/search"
+	"cicada/internal/server/store/user"
 	"context"
+	"crypto/rand"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
 	badger "github.com/dgraph-io/badger/v4"
 	clover "github.com/ostafen/clover/v2"
 	"log"
@@ -28,43 +38,66 @@ func main() {
 }
 
 func run() error {
-	if len(os.Args) != 2 {
-		log.Fatal("expected exactly one argument for listen address")
+	c, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	l, err := net.Listen("tcp", os.Args[1])
+	level, _ := c.Level()
+	slog.SetLogLoggerLevel(level)
+
+	l, err := net.Listen("tcp", c.Listen)
 	if err != nil {
-		log.Fatal("unable to listen on ", os.Args[1])
+		log.Fatal("unable to listen on ", c.Listen)
 	}
 
-	kvDb := kvStore("/tmp")
+	kvDb := kvStore(c.DataDir, "badger")
 	defer kvDb.Close()
 
-	objDb := objStore("/tmp")
+	searchDb := kvStore(c.DataDir, "search")
+	defer searchDb.Close()
+
+	objDb := objStore(c.DataDir)
 	defer objDb.Close()
 
+	imageStore := image.NewStore(kvDb)
+	searchStore := search.NewStore(searchDb)
+
 	quitChan := make(chan interface{})
-	chatService := server.New(quitChan, chat.NewStore(objDb), room.NewStore(objDb))
+	chatService := server.New(quitChan, c.Options(), chat.NewStore(objDb), room.NewStore(objDb), user.NewStore(objDb), imageStore, searchStore, audit.NewStore(objDb))
+	if err = buildSearchIndex(chatService, searchStore); err != nil {
+		return fmt.Errorf("unable to build search index: %w", err)
+	}
+
+	expvar.Publish("queues", expvar.Func(func() any { return chatService.QueueMetrics() }))
+
+	gcDone := make(chan interface{})
+	defer close(gcDone)
+	go collectImages(chatService, c.Images.GCInterval, c.Images.GCGrace, gcDone)
+	go pruneMessages(chatService, c.Retention.Interval, gcDone)
 
 	h := &HttpHandler{
 		chatService,
-		image.NewStore(kvDb),
+		imageStore,
+		auth.NewTokens(sessionKey(), c.SessionLifetime),
+		c.Images.MaxSize,
 	}
 
-	http.HandleFunc("POST /room", h.CreateRoom)
-	http.HandleFunc("PUT /room/:id:", h.Room)
-	http.HandleFunc("POST /message", h.SendMessage)
-	http.HandleFunc("POST /register", h.Connect)
-	http.HandleFunc("POST /unregister", h.Disconnect)
-	http.HandleFunc("GET /image/:id:", h.GetImage)
-	s := &http.Server{}
+	s := &http.Server{Handler: h.routes()}
 
 	errChan := make(chan error)
 	go func() {
-		errChan <- s.Serve(l)
+		if c.TLSEnabled() {
+			errChan <- s.ServeTLS(l, c.TLS.CertFile, c.TLS.KeyFile)
+		} else {
+			errChan <- s.Serve(l)
+		}
 	}()
+	slog.Info("serving", "address", l.Addr(), "tls", c.TLSEnabled(), "data", c.DataDir)
 
-	sigs := make(chan os.Signal)
+	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt)
 
 	select {
@@ -81,22 +114,89 @@ func run() error {
 	return s.Shutdown(ctx)
 }
 
-func makeTempDir(prefix, dbName string) string {
-	cicadaDir := filepath.Join(prefix, "cicada", dbName)
-	err := os.MkdirAll(cicadaDir, 0700)
-	checkError("failed to create database directory: "+cicadaDir, err)
-	return cicadaDir
+// collectImages periodically deletes images no message refers to, until done
+// is closed.
+func collectImages(cs *server.ChatService, interval, grace time.Duration, done chan interface{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			report, err := cs.CollectImages(grace)
+			if err != nil {
+				slog.Error("image collection failed", "error", err)
+				continue
+			}
+			slog.Info("collected images", "scanned", report.Scanned, "removed", report.Removed, "bytes", report.BytesReclaimed)
+		}
+	}
+}
+
+// pruneMessages periodically removes the messages that have outlived their
+// room's retention, until done is closed.
+func pruneMessages(cs *server.ChatService, interval time.Duration, done chan interface{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			report, err := cs.PruneMessages()
+			if err != nil {
+				slog.Error("message pruning failed", "removed", report.Messages, "error", err)
+				continue
+			}
+			slog.Info("pruned messages", "rooms", report.Rooms, "messages", report.Messages, "images", report.Images, "held", report.Held)
+		}
+	}
+}
+
+// buildSearchIndex indexes the stored messages when the search index is
+// empty, such as on first start or after its directory was removed to have
+// it rebuilt.
+func buildSearchIndex(cs *server.ChatService, ss *search.Store) error {
+	empty, err := ss.Empty()
+	if err != nil || !empty {
+		return err
+	}
+
+	start := time.Now()
+	count, err := cs.RebuildSearchIndex()
+	if err != nil {
+		return err
+	}
+	slog.Info("built search index", "messages", count, "elapsed", time.Since(start))
+	return nil
+}
+
+// sessionKey generates the key used to sign session tokens. Sessions do not
+// survive a restart of the server.
+func sessionKey() []byte {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	checkError("failed to generate session key", err)
+	return key
+}
+
+func makeDataDir(dataDir, dbName string) string {
+	dbDir := filepath.Join(dataDir, dbName)
+	err := os.MkdirAll(dbDir, 0700)
+	checkError("failed to create database directory: "+dbDir, err)
+	return dbDir
 }
 
-func objStore(tmpDir string) *clover.DB {
-	dbDir := makeTempDir(tmpDir, "clover")
+func objStore(dataDir string) *clover.DB {
+	dbDir := makeDataDir(dataDir, "clover")
 	db, err := clover.Open(dbDir)
 	checkError("failed to open clover database", err)
 	return db
 }
 
-func kvStore(tmpDir string) *badger.DB {
-	dbDir := makeTempDir(tmpDir, "badger")
+func kvStore(dataDir, dbName string) *badger.DB {
+	dbDir := makeDataDir(dataDir, dbName)
 	kv, err := badger.Open(badger.DefaultOptions(dbDir))
 	checkError("failed to open badger database", err)
 	return kv
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"cicada/internal/server"
+	"net"
+	"net/http"
+)
+
+// routes maps the http api onto the handler. Everything except creating an
+// account and logging in requires a session token.
+func (h *HttpHandler) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /user", h.CreateUser)
+	mux.HandleFunc("POST /login", h.Login)
+	mux.HandleFunc("GET /rooms", h.authenticated(h.Rooms))
+	mux.HandleFunc("GET /rooms/public", h.authenticated(h.PublicRooms))
+	mux.HandleFunc("POST /room", h.authenticated(h.CreateRoom))
+	mux.HandleFunc("PUT /room/{id}", h.authenticated(h.Room))
+	mux.HandleFunc("PATCH /room/{id}", h.authenticated(h.UpdateRoom))
+	mux.HandleFunc("PUT /room/{id}/role/{userId}", h.authenticated(h.SetRole))
+	mux.HandleFunc("POST /room/{id}/owner", h.authenticated(h.TransferOwnership))
+	mux.HandleFunc("PUT /room/{id}/retention", h.authenticated(h.SetRetention))
+	mux.HandleFunc("PUT /room/{id}/hold", h.authenticated(h.LegalHold))
+	mux.HandleFunc("DELETE /room/{id}/hold", h.authenticated(h.LegalHold))
+	mux.HandleFunc("GET /room/{id}/sanctions", h.authenticated(h.Sanctions))
+	mux.HandleFunc("POST /room/{id}/sanctions", h.authenticated(h.Sanction))
+	mux.HandleFunc("DELETE /room/{id}/sanctions/{sanctionId}", h.authenticated(h.RevokeSanction))
+	mux.HandleFunc("POST /direct/{userId}", h.authenticated(h.OpenDirect))
+	mux.HandleFunc("GET /room/{id}/messages", h.authenticated(h.History))
+	mux.HandleFunc("GET /room/{id}/presence", h.authenticated(h.RoomPresence))
+	mux.HandleFunc("POST /room/{id}/read", h.authenticated(h.MarkRead))
+	mux.HandleFunc("POST /room/{id}/invite", h.authenticated(h.CreateInvite))
+	mux.HandleFunc("GET /search", h.authenticated(h.Search))
+	mux.HandleFunc("POST /message", h.authenticated(h.SendMessage))
+	mux.HandleFunc("PATCH /message/{id}", h.authenticated(h.EditMessage))
+	mux.HandleFunc("DELETE /message/{id}", h.authenticated(h.DeleteMessage))
+	mux.HandleFunc("GET /message/{id}/revisions", h.authenticated(h.Revisions))
+	mux.HandleFunc("GET /message/{id}/replies", h.authenticated(h.Thread))
+	mux.HandleFunc("PUT /message/{id}/reactions/{emoji}", h.authenticated(h.React))
+	mux.HandleFunc("DELETE /message/{id}/reactions/{emoji}", h.authenticated(h.React))
+	mux.HandleFunc("GET /register", h.authenticated(h.Connect))
+	mux.HandleFunc("POST /unregister", h.authenticated(h.Disconnect))
+	mux.HandleFunc("POST /image", h.authenticated(h.UploadImage))
+	mux.HandleFunc("GET /image/{id}", h.authenticated(h.GetImage))
+	mux.HandleFunc("GET /user/{id}", h.authenticated(h.GetUser))
+	mux.HandleFunc("PATCH /user/{id}", h.authenticated(h.UpdateUser))
+	mux.HandleFunc("DELETE /user/{id}", h.authenticated(h.DeleteUser))
+	mux.HandleFunc("GET /admin/audit", h.authenticated(h.AuditLog))
+	mux.HandleFunc("GET /admin/metrics", h.authenticated(h.Metrics))
+	return sourced(mux)
+}
+
+// sourced passes the address each request came from to the chat service, so
+// the changes it makes are audited with it.
+func sourced(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		next.ServeHTTP(w, r.WithContext(server.WithSource(r.Context(), host)))
+	})
+}
@@ -5,3 +5,13 @@ import "errors"
 var ErrorNotFound error = errors.New("not found")
 
 var ErrorBadRequest error = errors.New("bad request")
+
+var ErrorForbidden error = errors.New("forbidden")
+
+var ErrorUnauthorized error = errors.New("unauthorized")
+
+var ErrorConflict error = errors.New("conflict")
+
+var ErrorTooLarge error = errors.New("too large")
+
+var ErrorUnsupportedMedia error = errors.New("unsupported media type")
@@ -0,0 +1,139 @@
+package cicada
+
+import "encoding/json"
+
+// Frame types a client sends over a cicada_v1 websocket connection.
+const (
+	FrameSendMessage    = "message.send"
+	FrameEditMessage    = "message.edit"
+	FrameDeleteMessage  = "message.delete"
+	FrameAddReaction    = "reaction.add"
+	FrameRemoveReaction = "reaction.remove"
+	FrameJoinRoom       = "room.join"
+	FrameLeaveRoom      = "room.leave"
+	FrameOpenDirect     = "direct.open"
+	FrameTyping         = "typing"
+	FramePing           = "ping"
+	FrameSetPresence    = "presence.set"
+	FrameRead           = "room.read"
+)
+
+// Frame types the server sends over a cicada_v1 websocket connection.
+const (
+	FrameAck       = "ack"
+	FrameError     = "error"
+	FramePong      = "pong"
+	FrameMessage   = "message"
+	FrameReply     = "thread.message"
+	FramePresence  = "presence"
+	FrameReceipt   = "receipt"
+	FrameEdited    = "message.edited"
+	FrameDeleted   = "message.deleted"
+	FrameReaction  = "reaction"
+	FrameReplayEnd = "replay.end"
+	FrameOverflow  = "overflow"
+)
+
+// Error codes carried in an error frame.
+const (
+	CodeBadRequest = "bad_request"
+	CodeForbidden  = "forbidden"
+	CodeConflict   = "conflict"
+	CodeNotFound   = "not_found"
+	CodeInternal   = "internal"
+)
+
+// Frame is the envelope for everything sent over a websocket connection.
+// Requests from the client carry an Id, which the server echoes in the
+// matching ack or error frame.
+type Frame struct {
+	Type    string          `json:"type"`
+	Id      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// EditPayload replaces the text of a message.
+type EditPayload struct {
+	Id   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// MessagePayload names the message a delete frame refers to.
+type MessagePayload struct {
+	Id string `json:"id"`
+}
+
+// ReactionPayload names an emoji to add to or remove from a message.
+type ReactionPayload struct {
+	MessageId string `json:"messageId"`
+	Emoji     string `json:"emoji"`
+}
+
+// ReactionUpdate tells room members that a user added or removed a reaction.
+// Users lists everyone who now has that reaction on the message.
+type ReactionUpdate struct {
+	MessageId string   `json:"messageId"`
+	RoomId    string   `json:"roomId"`
+	UserId    string   `json:"userId"`
+	Emoji     string   `json:"emoji"`
+	Added     bool     `json:"added"`
+	Users     []string `json:"users"`
+}
+
+// DirectPayload names the other user of a direct conversation.
+type DirectPayload struct {
+	UserId string `json:"userId"`
+}
+
+// RoomPayload names the room a join or leave frame refers to.
+type RoomPayload struct {
+	RoomId string `json:"roomId"`
+	// Invite is the token needed to join an invite-only or private room.
+	Invite string `json:"invite,omitempty"`
+}
+
+// TypingPayload signals that a user started or stopped typing in a room.
+type TypingPayload struct {
+	RoomId string `json:"roomId"`
+	UserId string `json:"userId,omitempty"`
+	Typing bool   `json:"typing"`
+}
+
+// ReadPayload marks a room as read up to a message. Without a message id the
+// room is read up to its newest message.
+type ReadPayload struct {
+	RoomId    string `json:"roomId"`
+	MessageId string `json:"messageId,omitempty"`
+}
+
+// PresencePayload sets the caller's own presence. Only online and away may
+// be set, offline follows from closing the connection.
+type PresencePayload struct {
+	State string `json:"state"`
+}
+
+// ReplayPayload marks the end of the messages a reconnecting client missed.
+// Cursor is the position of the last message replayed, or the position the
+// client resumed from when nothing was missed. More is set when the replay
+// stopped at the server's limit, in which case the rest of each room's
+// history is read with history requests after the cursor.
+type ReplayPayload struct {
+	Count  int    `json:"count"`
+	Cursor string `json:"cursor"`
+	More   bool   `json:"more"`
+}
+
+// OverflowPayload tells a client that frames were dropped because it fell
+// behind. Cursor is set when messages were among them, and is the position
+// to reconnect from to have them replayed. Messages the client already has
+// may be replayed too.
+type OverflowPayload struct {
+	Dropped int    `json:"dropped"`
+	Cursor  string `json:"cursor,omitempty"`
+}
+
+// ErrorPayload describes why a request frame failed.
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
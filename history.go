@@ -0,0 +1,12 @@
+package cicada
+
+// HistoryPage is a slice of a room's history. Before and After are opaque
+// cursors for the neighbouring pages, set only when there are more messages
+// in that direction. Threads summarises the replies to messages in the page
+// that have any, by message id.
+type HistoryPage struct {
+	Messages []ChatMessage     `json:"messages"`
+	Before   string            `json:"before,omitempty"`
+	After    string            `json:"after,omitempty"`
+	Threads  map[string]Thread `json:"threads,omitempty"`
+}
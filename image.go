@@ -1,8 +1,28 @@
 package cicada
 
+// Names of the smaller renditions generated for uploaded images.
+const (
+	RenditionThumb  = "thumb"
+	RenditionMedium = "medium"
+)
+
 // Image represents image metadata stored inside a chat log.
 type Image struct {
 	Id          string `clover:"id" json:"id"`
 	Name        string `clover:"name" json:"name"`
 	ContentType string `clover:"contentType" json:"contentType"`
+	Size        int64  `clover:"size" json:"size"`
+	Width       int    `clover:"width,omitempty" json:"width,omitempty"`
+	Height      int    `clover:"height,omitempty" json:"height,omitempty"`
+	// Renditions maps a rendition name to the scaled down copy of the image.
+	// Images that are already small, or could not be decoded, have none.
+	Renditions map[string]Rendition `clover:"renditions,omitempty" json:"renditions,omitempty"`
+}
+
+// Rendition describes a scaled down copy of an image.
+type Rendition struct {
+	ContentType string `clover:"contentType" json:"contentType"`
+	Size        int64  `clover:"size" json:"size"`
+	Width       int    `clover:"width" json:"width"`
+	Height      int    `clover:"height" json:"height"`
 }
@@ -0,0 +1,326 @@
+// Package config loads the server configuration. Settings are read from, in
+// increasing order of precedence, built in defaults, a TOML file, CICADA_*
+// environment variables and command line flags.
+package config
+
+import (
+	"cicada"
+	"cicada/internal/server"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// envPrefix starts the name of every environment variable read by Load. The
+// rest of the name is the flag name in upper case, with dashes replaced by
+// underscores, so -data-dir is read from CICADA_DATA_DIR.
+const envPrefix = "CICADA_"
+
+type Config struct {
+	// Listen is the address the server accepts connections on.
+	Listen string `toml:"listen"`
+	// DataDir holds the clover and badger databases.
+	DataDir string `toml:"data_dir"`
+	// LogLevel is one of debug, info, warn or error.
+	LogLevel string   `toml:"log_level"`
+	TLS      TLS      `toml:"tls"`
+	Messages Messages `toml:"messages"`
+	Images   Images   `toml:"images"`
+	// WriteTimeout bounds how long a write to a websocket may take.
+	WriteTimeout time.Duration `toml:"write_timeout"`
+	// SessionLifetime is how long a login token stays valid.
+	SessionLifetime time.Duration `toml:"session_lifetime"`
+	// PresenceGrace is how long a user may be disconnected before they are
+	// shown as offline.
+	PresenceGrace time.Duration `toml:"presence_grace"`
+	// InviteLifetime is how long an invite to a room can be used.
+	InviteLifetime time.Duration `toml:"invite_lifetime"`
+	Typing         Typing        `toml:"typing"`
+	Retention      Retention     `toml:"retention"`
+	Queue          Queue         `toml:"queue"`
+	// Admins are the ids of the users who may read the audit log and the
+	// metrics, and place rooms under legal hold.
+	Admins []string `toml:"admins"`
+}
+
+// Retention limits how long messages are kept in every room, and controls
+// how expired messages are pruned. Zero limits keep messages forever.
+type Retention struct {
+	MaxAge      time.Duration `toml:"max_age"`
+	MaxMessages int           `toml:"max_messages"`
+	// Interval is the time between pruning passes.
+	Interval time.Duration `toml:"interval"`
+	// BatchSize is the most messages removed from a room at once.
+	BatchSize int `toml:"batch_size"`
+}
+
+// Queue bounds the frames waiting to be written to each client.
+type Queue struct {
+	Size int `toml:"size"`
+	// Overflow is either drop_oldest, to discard the oldest waiting frame,
+	// or disconnect, to close the connection of a client that falls behind.
+	Overflow string `toml:"overflow"`
+}
+
+// Typing controls how typing notifications are relayed.
+type Typing struct {
+	// Timeout is how long a user is shown as typing after their last
+	// typing notification.
+	Timeout time.Duration `toml:"timeout"`
+	// Interval is the least time between relayed notifications from one
+	// user in one room.
+	Interval time.Duration `toml:"interval"`
+}
+
+// TLS names the certificate and key served over https. Both are required to
+// enable TLS, and the server uses plain http when neither is set.
+type TLS struct {
+	CertFile string `toml:"cert_file"`
+	KeyFile  string `toml:"key_file"`
+}
+
+// Messages limits the size of messages and history pages.
+type Messages struct {
+	MaxLength          int `toml:"max_length"`
+	MaxImages          int `toml:"max_images"`
+	HistoryPageSize    int `toml:"history_page_size"`
+	MaxHistoryPageSize int `toml:"max_history_page_size"`
+	MaxReplay          int `toml:"max_replay"`
+}
+
+// Images limits uploads and controls the collection of unreferenced images.
+type Images struct {
+	MaxSize    int64         `toml:"max_size"`
+	GCInterval time.Duration `toml:"gc_interval"`
+	GCGrace    time.Duration `toml:"gc_grace"`
+}
+
+// Default returns the configuration used when nothing is overridden.
+func Default() Config {
+	opts := server.DefaultOptions()
+	return Config{
+		Listen:   "localhost:8080",
+		DataDir:  filepath.Join(os.TempDir(), "cicada"),
+		LogLevel: "info",
+		Messages: Messages{
+			MaxLength:          opts.MaxMessageLength,
+			MaxImages:          opts.MaxMessageImages,
+			HistoryPageSize:    opts.HistoryPageSize,
+			MaxHistoryPageSize: opts.MaxHistoryPageSize,
+			MaxReplay:          opts.MaxReplay,
+		},
+		Images: Images{
+			MaxSize:    10 << 20,
+			GCInterval: time.Hour,
+			GCGrace:    24 * time.Hour,
+		},
+		WriteTimeout:    opts.WriteTimeout,
+		SessionLifetime: 24 * time.Hour,
+		PresenceGrace:   opts.PresenceGrace,
+		InviteLifetime:  opts.InviteLifetime,
+		Typing: Typing{
+			Timeout:  opts.TypingTimeout,
+			Interval: opts.TypingInterval,
+		},
+		Retention: Retention{
+			Interval:  time.Hour,
+			BatchSize: opts.PruneBatchSize,
+		},
+		Queue: Queue{
+			Size:     opts.QueueSize,
+			Overflow: string(opts.Overflow),
+		},
+	}
+}
+
+// Load builds the configuration from the command line arguments, the
+// environment and the file named by the -config flag or CICADA_CONFIG, then
+// validates it.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	c := Default()
+	fs := flag.NewFlagSet("cicada", flag.ContinueOnError)
+	path := fs.String("config", getenv(envPrefix+"CONFIG"), "path to a TOML configuration file")
+	fs.StringVar(&c.Listen, "listen", c.Listen, "address to listen on")
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "directory holding the databases")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "one of debug, info, warn or error")
+	fs.StringVar(&c.TLS.CertFile, "tls-cert-file", c.TLS.CertFile, "certificate to serve https with")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key-file", c.TLS.KeyFile, "private key for the certificate")
+	fs.IntVar(&c.Messages.MaxLength, "max-message-length", c.Messages.MaxLength, "longest message text accepted, in characters")
+	fs.IntVar(&c.Messages.MaxImages, "max-message-images", c.Messages.MaxImages, "most images attached to one message")
+	fs.IntVar(&c.Messages.HistoryPageSize, "history-page-size", c.Messages.HistoryPageSize, "messages returned by a history request without a limit")
+	fs.IntVar(&c.Messages.MaxHistoryPageSize, "max-history-page-size", c.Messages.MaxHistoryPageSize, "most messages returned by one history request")
+	fs.IntVar(&c.Messages.MaxReplay, "max-replay", c.Messages.MaxReplay, "most missed messages replayed to a reconnecting client")
+	fs.Int64Var(&c.Images.MaxSize, "max-image-size", c.Images.MaxSize, "largest image upload accepted, in bytes")
+	fs.DurationVar(&c.Images.GCInterval, "image-gc-interval", c.Images.GCInterval, "time between passes deleting unreferenced images")
+	fs.DurationVar(&c.Images.GCGrace, "image-gc-grace", c.Images.GCGrace, "how long an unreferenced image is kept after upload")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "longest time a websocket write may take")
+	fs.IntVar(&c.Queue.Size, "queue-size", c.Queue.Size, "most frames waiting to be written to one client")
+	fs.StringVar(&c.Queue.Overflow, "queue-overflow", c.Queue.Overflow, "drop_oldest or disconnect, when a client's queue is full")
+	fs.DurationVar(&c.SessionLifetime, "session-lifetime", c.SessionLifetime, "how long a login token stays valid")
+	fs.DurationVar(&c.Typing.Timeout, "typing-timeout", c.Typing.Timeout, "how long a user is shown as typing after their last notification")
+	fs.DurationVar(&c.Typing.Interval, "typing-interval", c.Typing.Interval, "least time between relayed typing notifications from a user")
+	fs.DurationVar(&c.PresenceGrace, "presence-grace", c.PresenceGrace, "how long a user may be disconnected before being shown as offline")
+	fs.DurationVar(&c.InviteLifetime, "invite-lifetime", c.InviteLifetime, "how long an invite to a room can be used")
+	fs.DurationVar(&c.Retention.MaxAge, "retention-max-age", c.Retention.MaxAge, "oldest message kept, or 0 to keep messages of any age")
+	fs.IntVar(&c.Retention.MaxMessages, "retention-max-messages", c.Retention.MaxMessages, "most messages kept in a room, or 0 for no limit")
+	fs.DurationVar(&c.Retention.Interval, "retention-interval", c.Retention.Interval, "time between passes pruning expired messages")
+	fs.IntVar(&c.Retention.BatchSize, "retention-batch-size", c.Retention.BatchSize, "most messages pruned from a room at once")
+	fs.Var((*list)(&c.Admins), "admins", "comma separated ids of the users who may read the audit log and metrics and place legal holds")
+
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	if fs.NArg() != 0 {
+		return c, fmt.Errorf("unexpected arguments %q, the listen address is set with -listen", fs.Args())
+	}
+
+	// the flags were parsed first to find the file, so remember what was
+	// given on the command line and apply it again over the file and the
+	// environment
+	given := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = f.Value.String()
+	})
+
+	c = Default()
+	if len(*path) != 0 {
+		md, err := toml.DecodeFile(*path, &c)
+		if err != nil {
+			return c, fmt.Errorf("unable to read config file %s: %w", *path, err)
+		}
+		if unknown := md.Undecoded(); len(unknown) != 0 {
+			return c, fmt.Errorf("unknown settings in config file %s: %v", *path, unknown)
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" {
+			return
+		}
+
+		value, ok := given[f.Name]
+		if !ok {
+			value = getenv(envName(f.Name))
+			ok = len(value) != 0
+		}
+		if ok {
+			if e := fs.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid value %q for %s: %w", value, f.Name, e)
+			}
+		}
+	})
+	if err != nil {
+		return c, err
+	}
+
+	return c, c.Validate()
+}
+
+// Validate checks that the configuration can be used to start a server.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(len(c.Listen) != 0, "listen address is empty")
+	check(len(c.DataDir) != 0, "data directory is empty")
+	_, err := c.Level()
+	check(err == nil, "unknown log level %q", c.LogLevel)
+	check((len(c.TLS.CertFile) == 0) == (len(c.TLS.KeyFile) == 0), "tls needs both a certificate and a key file")
+	check(c.Messages.MaxLength > 0, "max message length must be positive")
+	check(c.Messages.MaxImages >= 0, "max message images must not be negative")
+	check(c.Messages.HistoryPageSize > 0, "history page size must be positive")
+	check(c.Messages.MaxHistoryPageSize >= c.Messages.HistoryPageSize, "max history page size must be at least the history page size")
+	check(c.Messages.MaxReplay > 0, "max replay must be positive")
+	check(c.Images.MaxSize > 0, "max image size must be positive")
+	check(c.Images.GCInterval > 0, "image gc interval must be positive")
+	check(c.Images.GCGrace > 0, "image gc grace must be positive")
+	check(c.WriteTimeout > 0, "write timeout must be positive")
+	check(c.Queue.Size > 0, "queue size must be positive")
+	check(c.Queue.Overflow == string(server.DropOldest) || c.Queue.Overflow == string(server.Disconnect), "unknown queue overflow %q", c.Queue.Overflow)
+	check(c.SessionLifetime > 0, "session lifetime must be positive")
+	check(c.PresenceGrace >= 0, "presence grace must not be negative")
+	check(c.InviteLifetime > 0, "invite lifetime must be positive")
+	check(c.Typing.Timeout > 0, "typing timeout must be positive")
+	check(c.Typing.Interval >= 0 && c.Typing.Interval < c.Typing.Timeout, "typing interval must be less than the typing timeout")
+	check(c.Retention.MaxAge >= 0, "retention max age must not be negative")
+	check(c.Retention.MaxMessages >= 0, "retention max messages must not be negative")
+	check(c.Retention.Interval > 0, "retention interval must be positive")
+	check(c.Retention.BatchSize > 0, "retention batch size must be positive")
+
+	for _, file := range []string{c.TLS.CertFile, c.TLS.KeyFile} {
+		if len(file) != 0 {
+			_, err := os.Stat(file)
+			check(err == nil, "unable to read tls file: %v", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// TLSEnabled reports whether the server should serve https.
+func (c Config) TLSEnabled() bool {
+	return len(c.TLS.CertFile) != 0
+}
+
+// Options returns the limits and timeouts for the chat service.
+func (c Config) Options() server.Options {
+	return server.Options{
+		MaxMessageLength:   c.Messages.MaxLength,
+		MaxMessageImages:   c.Messages.MaxImages,
+		HistoryPageSize:    c.Messages.HistoryPageSize,
+		MaxHistoryPageSize: c.Messages.MaxHistoryPageSize,
+		MaxReplay:          c.Messages.MaxReplay,
+		WriteTimeout:       c.WriteTimeout,
+		QueueSize:          c.Queue.Size,
+		Overflow:           server.Overflow(c.Queue.Overflow),
+		PresenceGrace:      c.PresenceGrace,
+		TypingTimeout:      c.Typing.Timeout,
+		TypingInterval:     c.Typing.Interval,
+		InviteLifetime:     c.InviteLifetime,
+		Admins:             c.Admins,
+		Retention:          cicada.Retention{MaxAge: c.Retention.MaxAge, MaxMessages: c.Retention.MaxMessages},
+		PruneBatchSize:     c.Retention.BatchSize,
+	}
+}
+
+// Level returns the configured log level.
+func (c Config) Level() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(c.LogLevel))
+	return level, err
+}
+
+// list is a flag holding a comma separated list of values.
+type list []string
+
+func (l *list) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *list) Set(value string) error {
+	*l = nil
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
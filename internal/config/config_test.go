@@ -0,0 +1,135 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func environment(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+func writeFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "cicada.toml")
+	err := os.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal("unable to write config file", err)
+	}
+	return path
+}
+
+func TestDefaults(t *testing.T) {
+	c, err := Load(nil, environment(nil))
+	if err != nil {
+		t.Fatal("default config should be valid", err)
+	}
+
+	if c.Listen != Default().Listen || c.WriteTimeout != 3*time.Second {
+		t.Error("expected the default config, got", c)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	path := writeFile(t, `
+listen = "file:1"
+data_dir = "/var/lib/cicada"
+write_timeout = "5s"
+
+[messages]
+max_length = 100
+history_page_size = 20
+`)
+
+	env := environment(map[string]string{
+		"CICADA_CONFIG":             path,
+		"CICADA_LISTEN":             "env:1",
+		"CICADA_MAX_MESSAGE_LENGTH": "200",
+	})
+
+	c, err := Load([]string{"-listen", "flag:1"}, env)
+	if err != nil {
+		t.Fatal("error loading config", err)
+	}
+
+	if c.Listen != "flag:1" {
+		t.Error("expected flags to override the environment, got", c.Listen)
+	}
+	if c.Messages.MaxLength != 200 {
+		t.Error("expected the environment to override the file, got", c.Messages.MaxLength)
+	}
+	if c.DataDir != "/var/lib/cicada" || c.WriteTimeout != 5*time.Second || c.Messages.HistoryPageSize != 20 {
+		t.Error("expected settings from the file, got", c)
+	}
+	if c.Messages.MaxHistoryPageSize != Default().Messages.MaxHistoryPageSize {
+		t.Error("expected defaults for settings missing from the file, got", c.Messages.MaxHistoryPageSize)
+	}
+}
+
+func TestAdmins(t *testing.T) {
+	path := writeFile(t, `admins = ["user1", "user2"]`)
+
+	c, err := Load([]string{"-config", path}, environment(nil))
+	if err != nil {
+		t.Fatal("error loading config", err)
+	}
+	if !reflect.DeepEqual(c.Options().Admins, []string{"user1", "user2"}) {
+		t.Error("expected admins from the file, got", c.Admins)
+	}
+
+	c, err = Load(nil, environment(map[string]string{
+		"CICADA_CONFIG": path,
+		"CICADA_ADMINS": "user3, user4,",
+	}))
+	if err != nil {
+		t.Fatal("error loading config", err)
+	}
+	if !reflect.DeepEqual(c.Admins, []string{"user3", "user4"}) {
+		t.Error("expected the environment to override the file, got", c.Admins)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		file string
+		want string
+	}{
+		{"positional", []string{"localhost:80"}, "", "unexpected arguments"},
+		{"level", []string{"-log-level", "loud"}, "", "log level"},
+		{"tls", []string{"-tls-cert-file", "cert.pem"}, "", "key file"},
+		{"pages", []string{"-max-history-page-size", "10"}, "", "history page size"},
+		{"timeout", []string{"-write-timeout", "0s"}, "", "write timeout"},
+		{"retention", []string{"-retention-max-messages", "-1"}, "", "retention max messages"},
+		{"overflow", []string{"-queue-overflow", "block"}, "", "unknown queue overflow"},
+		{"unknown", nil, "lisen = \"typo\"", "unknown settings"},
+		{"syntax", nil, "listen = ", "unable to read"},
+	}
+
+	for _, test := range tests {
+		args := test.args
+		if len(test.file) != 0 {
+			args = append(args, "-config", writeFile(t, test.file))
+		}
+
+		_, err := Load(args, environment(nil))
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		} else if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: expected an error about %q, got %v", test.name, test.want, err)
+		}
+	}
+}
+
+func TestBadEnvironment(t *testing.T) {
+	_, err := Load(nil, environment(map[string]string{"CICADA_MAX_IMAGE_SIZE": "big"}))
+	if err == nil || !strings.Contains(err.Error(), "max-image-size") {
+		t.Error("expected an error naming the bad setting, got", err)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"cicada"
+	"cicada/internal/server/store/audit"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+type sourceKey struct{}
+
+// WithSource returns a context carrying the address a request came from, so
+// the changes made on its behalf are audited with it.
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+func sourceOf(ctx context.Context) string {
+	source, _ := ctx.Value(sourceKey{}).(string)
+	return source
+}
+
+// audit records a change in the audit log. Account, room, membership and
+// moderation changes are audited, as are edits and deletions of messages and
+// the messages pruned from each room. Message text stays in the rooms, and
+// reactions, read markers, presence and typing are not recorded. The change
+// has already been made, so failing to record it is logged rather than
+// returned.
+func (s *ChatService) audit(ctx context.Context, e cicada.AuditEvent) {
+	e.Date = time.Now()
+	e.Source = sourceOf(ctx)
+	if _, err := s.as.Append(e); err != nil {
+		slog.Error("unable to record audit event", "action", e.Action, "actor", e.Actor, "error", err)
+	}
+}
+
+// IsAdmin reports whether a user is one of the administrators named in the
+// options.
+func (s *ChatService) IsAdmin(userId string) bool {
+	return slices.Contains(s.opts.Admins, userId)
+}
+
+// AuditLog returns the audit events matching a query, newest first. Only
+// administrators may read the log. Without a limit, a history page's worth
+// of events is returned.
+func (s *ChatService) AuditLog(userId string, q audit.Query) ([]cicada.AuditEvent, error) {
+	if !s.IsAdmin(userId) {
+		return nil, fmt.Errorf("%w: only administrators may read the audit log", cicada.ErrorForbidden)
+	}
+
+	if q.Limit > s.opts.MaxHistoryPageSize {
+		return nil, fmt.Errorf("%w: limit must be at most %d", cicada.ErrorBadRequest, s.opts.MaxHistoryPageSize)
+	}
+	if q.Limit <= 0 {
+		q.Limit = s.opts.HistoryPageSize
+	}
+	return s.as.Find(q)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"cicada"
+	"cicada/internal/server/store/audit"
+	"context"
+	"errors"
+	uuid "github.com/satori/go.uuid"
+	"testing"
+	"time"
+)
+
+func TestAuditModeration(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Retention = cicada.Retention{MaxAge: time.Hour}
+	s, done := service(opts)
+	defer done()
+	ctx := WithSource(context.Background(), "192.0.2.1")
+
+	admin := newUser(s, "admin")
+	s.opts.Admins = []string{admin}
+	owner, mod, alice, bob := newUser(s, "owner"), newUser(s, "mod"), newUser(s, "alice"), newUser(s, "bob")
+	r := newRoom(t, s, owner, mod, alice, bob)
+
+	m, err := s.SendMessage(cicada.ChatMessage{RoomId: r.Id, Sender: alice, Text: "spam"})
+	if err != nil {
+		t.Fatal("unable to send message", err)
+	}
+	if _, err = s.SetRole(ctx, owner, r.Id, mod, cicada.RoleModerator); err != nil {
+		t.Fatal("unable to promote moderator", err)
+	}
+	if _, err = s.DeleteMessage(ctx, mod, m.Id); err != nil {
+		t.Fatal("unable to delete message", err)
+	}
+	if _, err = s.Kick(ctx, mod, r.Id, alice, "spam"); err != nil {
+		t.Fatal("unable to kick", err)
+	}
+	if _, err = s.Ban(ctx, mod, r.Id, bob, "", 0); err != nil {
+		t.Fatal("unable to ban", err)
+	}
+
+	if _, err = s.AuditLog(mod, audit.Query{RoomId: r.Id}); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected forbidden for a moderator reading the log, got", err)
+	}
+
+	expected := []struct {
+		action string
+		target string
+		change string
+		value  string
+	}{
+		{cicada.AuditSanction, bob, "action", cicada.SanctionBan},
+		{cicada.AuditSanction, alice, "action", cicada.SanctionKick},
+		{cicada.AuditMessageDelete, m.Id, "sender", alice},
+	}
+	events, err := s.AuditLog(admin, audit.Query{Actor: mod, Limit: len(expected)})
+	if err != nil {
+		t.Fatal("unable to read audit log", err)
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events by the moderator, got %+v", len(expected), events)
+	}
+	for i, e := range expected {
+		got := events[i]
+		if got.Action != e.action || got.Target != e.target || got.Changes[e.change] != e.value {
+			t.Errorf("event %d: expected %s of %s with %s %s, got %+v", i, e.action, e.target, e.change, e.value, got)
+		}
+		if got.RoomId != r.Id || got.Source != "192.0.2.1" {
+			t.Errorf("event %d: expected room %s from the request's source, got %+v", i, r.Id, got)
+		}
+	}
+
+	events, err = s.AuditLog(admin, audit.Query{Actor: owner, RoomId: r.Id, Limit: 1})
+	if err != nil || len(events) != 1 {
+		t.Fatal("expected the role change to be audited", events, err)
+	}
+	if e := events[0]; e.Action != cicada.AuditRoomRole || e.Target != mod || e.Changes["role"] != cicada.RoleModerator {
+		t.Error("expected the promotion to be audited, got", e)
+	}
+
+	// pruning is done by the server, not on behalf of anyone
+	old := cicada.ChatMessage{Id: uuid.NewV4().String(), Date: time.Now().Add(-2 * time.Hour), RoomId: r.Id, Sender: owner, Text: "old news"}
+	if err = s.cs.Save(old); err != nil {
+		t.Fatal("unable to save message", err)
+	}
+	if _, err = s.PruneMessages(); err != nil {
+		t.Fatal("unable to prune messages", err)
+	}
+	events, err = s.AuditLog(admin, audit.Query{Actor: systemSender})
+	if err != nil || len(events) != 1 {
+		t.Fatal("expected the prune to be audited", events, err)
+	}
+	if e := events[0]; e.Action != cicada.AuditRoomPrune || e.RoomId != r.Id || e.Changes["messages"] != "1" {
+		t.Error("expected the pruned messages to be audited, got", e)
+	}
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"cicada"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MinPasswordLength is the shortest password, in bytes, accepted for an
+	// account.
+	MinPasswordLength = 8
+	// MaxPasswordLength is the longest password bcrypt can hash.
+	MaxPasswordLength = 72
+)
+
+var encoding = base64.RawURLEncoding
+
+// dummyHash stands in for the hash of a user that does not exist, so failing
+// to log in takes as long whether or not the name is taken.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("no such user"), bcrypt.DefaultCost)
+	return hash
+})
+
+// Tokens issues and verifies signed session tokens. A token names the user it
+// was issued to and when it expires, followed by an HMAC-SHA256 signature.
+type Tokens struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+}
+
+type claims struct {
+	Subject string `json:"sub"`
+	Expires int64  `json:"exp"`
+}
+
+func NewTokens(key []byte, ttl time.Duration) *Tokens {
+	return &Tokens{key: key, ttl: ttl, now: time.Now}
+}
+
+// Issue creates a session token for the user.
+func (t *Tokens) Issue(userId string) (string, time.Time, error) {
+	expires := t.now().Add(t.ttl).Truncate(time.Second)
+	payload, err := json.Marshal(claims{Subject: userId, Expires: expires.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	body := encoding.EncodeToString(payload)
+	return body + "." + encoding.EncodeToString(t.sign(body)), expires, nil
+}
+
+// Verify checks the signature and expiry of a token and returns the user it
+// was issued to.
+func (t *Tokens) Verify(token string) (string, error) {
+	body, sig, found := strings.Cut(token, ".")
+	if !found {
+		return "", fmt.Errorf("%w: malformed token", cicada.ErrorUnauthorized)
+	}
+
+	mac, err := encoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, t.sign(body)) {
+		return "", fmt.Errorf("%w: invalid token signature", cicada.ErrorUnauthorized)
+	}
+
+	payload, err := encoding.DecodeString(body)
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed token", cicada.ErrorUnauthorized)
+	}
+
+	c := claims{}
+	if err = json.Unmarshal(payload, &c); err != nil || len(c.Subject) == 0 {
+		return "", fmt.Errorf("%w: malformed token", cicada.ErrorUnauthorized)
+	}
+
+	if !t.now().Before(time.Unix(c.Expires, 0)) {
+		return "", fmt.Errorf("%w: token expired", cicada.ErrorUnauthorized)
+	}
+	return c.Subject, nil
+}
+
+func (t *Tokens) sign(body string) []byte {
+	h := hmac.New(sha256.New, t.key)
+	h.Write([]byte(body))
+	return h.Sum(nil)
+}
+
+// HashPassword validates a password and returns its bcrypt hash.
+func HashPassword(password string) ([]byte, error) {
+	if len(password) < MinPasswordLength {
+		return nil, fmt.Errorf("%w: password must be at least %d characters", cicada.ErrorBadRequest, MinPasswordLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return nil, fmt.Errorf("%w: password must be at most %d bytes", cicada.ErrorBadRequest, MaxPasswordLength)
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// CheckPassword compares a password with a hash from HashPassword.
+func CheckPassword(hash []byte, password string) error {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return fmt.Errorf("%w: invalid credentials", cicada.ErrorUnauthorized)
+	}
+	return err
+}
+
+// RejectPassword fails like CheckPassword does for a wrong password, after
+// spending as long comparing it.
+func RejectPassword(password string) error {
+	_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+	return fmt.Errorf("%w: invalid credentials", cicada.ErrorUnauthorized)
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"cicada"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenRoundTrip(t *testing.T) {
+	tokens := NewTokens([]byte("secret"), time.Hour)
+	token, expires, err := tokens.Issue("user1")
+	if err != nil {
+		t.Fatal("failed to issue token", err)
+	}
+
+	if expires.Before(time.Now()) {
+		t.Error("token expires in the past", expires)
+	}
+
+	uid, err := tokens.Verify(token)
+	if err != nil {
+		t.Fatal("failed to verify token", err)
+	}
+	if uid != "user1" {
+		t.Errorf("expected user1, got %s", uid)
+	}
+}
+
+func TestTokenExpired(t *testing.T) {
+	tokens := NewTokens([]byte("secret"), time.Hour)
+	token, _, err := tokens.Issue("user1")
+	if err != nil {
+		t.Fatal("failed to issue token", err)
+	}
+
+	tokens.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, err = tokens.Verify(token)
+	if !errors.Is(err, cicada.ErrorUnauthorized) {
+		t.Error("expected unauthorized, got", err)
+	}
+}
+
+func TestTokenTampered(t *testing.T) {
+	tokens := NewTokens([]byte("secret"), time.Hour)
+	token, _, err := tokens.Issue("user1")
+	if err != nil {
+		t.Fatal("failed to issue token", err)
+	}
+
+	other, _, _ := NewTokens([]byte("other"), time.Hour).Issue("user2")
+	body, _, _ := strings.Cut(other, ".")
+	_, sig, _ := strings.Cut(token, ".")
+
+	for _, bad := range []string{"", "garbage", body + "." + sig, token + "x"} {
+		_, err = tokens.Verify(bad)
+		if !errors.Is(err, cicada.ErrorUnauthorized) {
+			t.Errorf("expected unauthorized for %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestPassword(t *testing.T) {
+	_, err := HashPassword("short")
+	if !errors.Is(err, cicada.ErrorBadRequest) {
+		t.Error("expected bad request for a short password, got", err)
+	}
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal("failed to hash password", err)
+	}
+
+	if err = CheckPassword(hash, "correct horse"); err != nil {
+		t.Error("password did not match its hash", err)
+	}
+	if err = CheckPassword(hash, "battery staple"); !errors.Is(err, cicada.ErrorUnauthorized) {
+		t.Error("expected unauthorized for the wrong password, got", err)
+	}
+	if err = RejectPassword("correct horse"); !errors.Is(err, cicada.ErrorUnauthorized) {
+		t.Error("expected unauthorized for an unknown user, got", err)
+	}
+}
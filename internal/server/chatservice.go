@@ -2,44 +2,151 @@ package server
 
 import (
 	"cicada"
+	"cicada/internal/server/auth"
+	"cicada/internal/server/store/audit"
 	"cicada/internal/server/store/chat"
+	"cicada/internal/server/store/image"
 	"cicada/internal/server/store/room"
+	"cicada/internal/server/store/search"
+	"cicada/internal/server/store/user"
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
 	uuid "github.com/satori/go.uuid"
 	"log/slog"
 	"nhooyr.io/websocket"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
+const (
+	// MaxUserNameLength is the longest user name, in characters, the service
+	// accepts.
+	MaxUserNameLength = 64
+	// MaxEmojiLength is the longest reaction, in characters. Emoji built
+	// from several code points, such as flags and families, fit well within it.
+	MaxEmojiLength = 16
+)
+
+// Options holds the limits and timeouts enforced by a ChatService.
+type Options struct {
+	// MaxMessageLength is the longest message text, in characters, the
+	// service accepts.
+	MaxMessageLength int
+	// MaxMessageImages is the most images that can be attached to one message.
+	MaxMessageImages int
+	// HistoryPageSize is the number of messages returned when joining a room
+	// or when a history request does not give a limit.
+	HistoryPageSize int
+	// MaxHistoryPageSize is the most messages returned by one history request.
+	MaxHistoryPageSize int
+	// WriteTimeout bounds how long a write to a websocket may take before
+	// the connection is dropped.
+	WriteTimeout time.Duration
+	// QueueSize is the most frames waiting to be written to one client.
+	QueueSize int
+	// Overflow is what happens to a client whose queue is full.
+	Overflow Overflow
+	// PresenceGrace is how long a user may be disconnected before they are
+	// announced as offline, so brief reconnects go unnoticed.
+	PresenceGrace time.Duration
+	// TypingTimeout is how long a user is shown as typing after their last
+	// typing notification.
+	TypingTimeout time.Duration
+	// TypingInterval is the least time between relayed typing notifications
+	// from one user in one room.
+	TypingInterval time.Duration
+	// MaxReplay is the most missed messages replayed to a client that
+	// reconnects. Anything older is read with history requests.
+	MaxReplay int
+	// InviteLifetime is how long an invite to a room can be used.
+	InviteLifetime time.Duration
+	// Admins are the ids of the users who may read the audit log and the
+	// metrics, and place rooms under legal hold.
+	Admins []string
+	// Retention limits how long messages are kept in every room. Rooms may
+	// set stricter limits of their own.
+	Retention cicada.Retention
+	// PruneBatchSize is the most messages removed from a room at once when
+	// pruning, so the service lock is not held for long.
+	PruneBatchSize int
+}
+
+// DefaultOptions returns the options used when none are configured.
+func DefaultOptions() Options {
+	return Options{
+		MaxMessageLength:   4000,
+		MaxMessageImages:   10,
+		HistoryPageSize:    100,
+		MaxHistoryPageSize: 500,
+		WriteTimeout:       3 * time.Second,
+		QueueSize:          256,
+		Overflow:           DropOldest,
+		PresenceGrace:      10 * time.Second,
+		TypingTimeout:      6 * time.Second,
+		TypingInterval:     2 * time.Second,
+		MaxReplay:          1000,
+		InviteLifetime:     7 * 24 * time.Hour,
+		PruneBatchSize:     500,
+	}
+}
+
 type ChatService struct {
-	m       *sync.Mutex
-	clients map[string]subscription
-	cs      *chat.Store
-	rs      *room.Store
+	m            *sync.Mutex
+	opts         Options
+	clients      map[string]subscription
+	presence     map[string]*presence
+	typing       typingTracker
+	dropped      atomic.Int64
+	disconnected atomic.Int64
+	cs           *chat.Store
+	rs           *room.Store
+	us           *user.Store
+	is           *image.Store
+	ss           *search.Store
+	as           *audit.Store
 }
 
+// subscription is a user's connection. Frames pushed to it wait in message,
+// which holds at most Options.QueueSize of them, until the write loop sends
+// them. Replies to the client's own requests wait in replies instead, so
+// they are never dropped.
 type subscription struct {
+	userId  string
 	message chan []byte
+	replies chan []byte
+	gap     *gap
 	quit    chan interface{}
 }
 
-func New(quitChan chan interface{}, cs *chat.Store, rs *room.Store) *ChatService {
+func New(quitChan chan interface{}, opts Options, cs *chat.Store, rs *room.Store, us *user.Store, is *image.Store, ss *search.Store, as *audit.Store) *ChatService {
 	service := &ChatService{
-		m:       &sync.Mutex{},
-		clients: make(map[string]subscription),
-		cs:      cs,
-		rs:      rs,
+		m:        &sync.Mutex{},
+		opts:     opts,
+		clients:  make(map[string]subscription),
+		presence: make(map[string]*presence),
+		typing:   typingTracker{states: make(map[typingKey]*typingState)},
+		cs:       cs,
+		rs:       rs,
+		us:       us,
+		is:       is,
+		ss:       ss,
+		as:       as,
 	}
 
 	// disconnect all the clients on quit
 	go func() {
 		select {
 		case <-quitChan:
-			for _, v := range service.clients {
-				v.quit <- true
+			service.m.Lock()
+			defer service.m.Unlock()
+			for k, v := range service.clients {
+				close(v.quit)
+				delete(service.clients, k)
 			}
 		}
 	}()
@@ -47,81 +154,312 @@ func New(quitChan chan interface{}, cs *chat.Store, rs *room.Store) *ChatService
 	return service
 }
 
-func (s *ChatService) Connect(userId string, ws *websocket.Conn) {
-	mesgChan := make(chan []byte)
-	quitChan := make(chan interface{})
-	s.clients[userId] = subscription{
-		message: mesgChan,
-		quit:    quitChan,
+// Connect registers a websocket for the user and starts serving frames on it.
+// A previous connection for the same user is closed. Changes requested over
+// the websocket are audited with the source carried by ctx.
+//
+// A client resuming from a cursor is first sent the messages it missed in
+// each of its rooms, followed by a replay.end frame. Messages sent in the
+// meantime are delivered after the replay, once each.
+func (s *ChatService) Connect(ctx context.Context, userId string, since chat.Cursor, ws *websocket.Conn) error {
+	if _, err := s.us.Get(userId); err != nil {
+		return err
+	}
+
+	sub := subscription{
+		userId:  userId,
+		message: make(chan []byte, s.opts.QueueSize),
+		replies: make(chan []byte, s.opts.QueueSize),
+		gap:     &gap{},
+		quit:    make(chan interface{}),
 	}
-	go writeLoop(mesgChan, quitChan, ws)
+
+	var missed chan replay
+	if !since.IsZero() {
+		missed = make(chan replay, 1)
+	}
+
+	s.m.Lock()
+	if old, ok := s.clients[userId]; ok {
+		close(old.quit)
+	}
+	s.clients[userId] = sub
+	s.m.Unlock()
+
+	s.markOnline(userId)
+	go s.writeLoop(sub, missed, ws)
+	if missed != nil {
+		go s.replay(userId, since, missed)
+	}
+	// the connection outlives the request that opened it, so keep only its source
+	go s.readLoop(WithSource(context.Background(), sourceOf(ctx)), userId, sub, ws)
+	return nil
 }
 
+// Disconnect closes a user's connection and announces them as offline
+// straight away, since they chose to leave.
 func (s *ChatService) Disconnect(userId string) error {
-	if sub, ok := s.clients[userId]; ok {
-		sub.quit <- true
+	s.m.Lock()
+	sub, ok := s.clients[userId]
+	if ok {
+		close(sub.quit)
+		delete(s.clients, userId)
+	}
+	s.m.Unlock()
+
+	if !ok {
+		return cicada.ErrorNotFound
+	}
+	s.markOffline(userId)
+	return nil
+}
+
+// unsubscribe removes sub if it is still the user's current subscription,
+// and reports whether it was.
+func (s *ChatService) unsubscribe(userId string, sub subscription) bool {
+	s.m.Lock()
+	cur, ok := s.clients[userId]
+	removed := ok && cur.quit == sub.quit
+	if removed {
+		close(sub.quit)
 		delete(s.clients, userId)
-		return nil
 	}
-	return cicada.ErrorNotFound
+	s.m.Unlock()
+
+	if removed {
+		s.markDisconnected(userId)
+	}
+	return removed
 }
 
-func (s *ChatService) SendMessage(m cicada.ChatMessage) error {
+// SendMessage stores a message from a room member and delivers it to the room.
+// The id and date are always assigned by the server. A message with a parent
+// id is a reply in that message's thread.
+func (s *ChatService) SendMessage(m cicada.ChatMessage) (cicada.ChatMessage, error) {
+	if err := s.validateMessage(m); err != nil {
+		return cicada.ChatMessage{}, err
+	}
+
 	r, err := s.rs.Get(m.RoomId)
 	if err != nil {
-		return errors.New("no room with id " + m.RoomId)
+		return cicada.ChatMessage{}, fmt.Errorf("%w: no room with id %s", err, m.RoomId)
+	}
+
+	if err = s.authorize(r, m.Sender, PermPost); err != nil {
+		return cicada.ChatMessage{}, err
+	}
+
+	if len(m.ParentId) != 0 {
+		if err = s.checkParent(m); err != nil {
+			return cicada.ChatMessage{}, err
+		}
+	}
+
+	if err = s.attachImages(&m); err != nil {
+		return cicada.ChatMessage{}, err
 	}
 
-	err = s.cs.Save(m)
+	m.Id = uuid.NewV4().String()
+	m.Date = time.Now()
+	if err = s.publish(r, m); err != nil {
+		s.releaseImages(imageIds(m.Images))
+		return cicada.ChatMessage{}, err
+	}
+
+	s.clearTyping(m.Sender, m.RoomId)
+	return m, nil
+}
+
+// attachImages replaces the images sent by the client with the stored
+// metadata and takes a reference to each of them, so they are kept for as
+// long as the message exists.
+func (s *ChatService) attachImages(m *cicada.ChatMessage) error {
+	for i, img := range m.Images {
+		stored, err := s.is.Metadata(img.Id)
+		if errors.Is(err, cicada.ErrorNotFound) {
+			return fmt.Errorf("%w: unknown image %s", cicada.ErrorBadRequest, img.Id)
+		} else if err != nil {
+			return err
+		}
+		m.Images[i] = stored
+	}
+
+	err := s.is.Acquire(imageIds(m.Images)...)
+	if errors.Is(err, cicada.ErrorNotFound) {
+		return fmt.Errorf("%w: %w", cicada.ErrorBadRequest, err)
+	}
+	return err
+}
+
+// releaseImages drops references held by deleted messages, so the next
+// collection pass can reclaim images nothing points to anymore. A failure
+// keeps the images stored, which wastes space but loses nothing.
+func (s *ChatService) releaseImages(ids []string) {
+	if err := s.is.Release(ids...); err != nil {
+		slog.Error("unable to release images", "error", err)
+	}
+}
+
+// CollectImages deletes images that no message refers to. Images uploaded or
+// referenced within the grace period are kept, since a message may be about
+// to use them.
+func (s *ChatService) CollectImages(grace time.Duration) (image.Report, error) {
+	live, err := s.cs.ReferencedImages()
+	if err != nil {
+		return image.Report{}, err
+	}
+	return s.is.Collect(live, grace)
+}
+
+// announce posts a system message to a room.
+func (s *ChatService) announce(roomId, text string) {
+	r, err := s.rs.Get(roomId)
+	if err == nil {
+		err = s.publish(r, systemMessage(roomId, text))
+	}
+	if err != nil {
+		slog.Error("unable to announce to room", "room", roomId, "error", err)
+	}
+}
+
+// publish saves a message and pushes it to the connected members of the room.
+func (s *ChatService) publish(r cicada.Room, m cicada.ChatMessage) error {
+	err := s.cs.Save(m)
 	if err != nil {
 		return err
 	}
 
-	bytes, err := json.Marshal(m)
+	f, err := messageFrame(m)
 	if err != nil {
 		return err
 	}
 
+	s.broadcast(r.Members, "", f)
+	s.index(m)
+	return nil
+}
+
+// messageFrame encodes a message for delivery. Replies are sent as thread
+// messages, so clients can keep them out of the main timeline.
+func messageFrame(m cicada.ChatMessage) ([]byte, error) {
+	frameType := cicada.FrameMessage
+	if len(m.ParentId) != 0 {
+		frameType = cicada.FrameReply
+	}
+	return newFrame(frameType, "", m)
+}
+
+// CreateRoom creates a room on behalf of a user, who is always one of its
+// members and its owner. Direct conversations are opened with OpenDirect
+// instead.
+func (s *ChatService) CreateRoom(ctx context.Context, userId string, r cicada.Room) (cicada.Room, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	r.Members = uniqueMembers(append([]string{userId}, r.Members...))
+	if err := validateVisibility(r.Visibility); err != nil {
+		return cicada.Room{}, err
+	}
+	if len(r.Visibility) == 0 {
+		r.Visibility = cicada.VisibilityPublic
+	}
+
+	// ids are assigned by the store, so a room cannot take the place of
+	// another, such as a direct conversation
+	r.Id = ""
+	r.Roles = map[string]string{userId: cicada.RoleOwner}
+	r.Direct = false
+	r.Participants = nil
+	// retention and legal hold are set with SetRetention and SetLegalHold,
+	// where they are authorised and audited
+	r.Retention = nil
+	r.LegalHold = false
 	for _, uid := range r.Members {
-		sub, ok := s.clients[uid]
-		if !ok {
-			continue
+		if _, err := s.us.Get(uid); err != nil {
+			return cicada.Room{}, fmt.Errorf("%w: unknown member %s", cicada.ErrorBadRequest, uid)
 		}
-		sub.message <- bytes
 	}
-	return nil
-}
 
-func (s *ChatService) CreateRoom(r cicada.Room) (cicada.Room, error) {
 	id, err := s.rs.Put(r)
 	if err != nil {
 		return cicada.Room{}, err
 	}
 	r.Id = id
+
+	for _, uid := range r.Members {
+		if err = s.us.AddRoom(uid, id); err != nil {
+			return cicada.Room{}, err
+		}
+	}
+
+	s.audit(ctx, cicada.AuditEvent{
+		Actor:  userId,
+		Action: cicada.AuditRoomCreate,
+		RoomId: id,
+		Changes: map[string]string{
+			"name":        r.Name,
+			"description": r.Description,
+			"visibility":  r.Visibility,
+			"members":     strings.Join(r.Members, ","),
+		},
+	})
 	return r, nil
 }
 
-func (s *ChatService) JoinRoom(userId, roomId string) ([]cicada.ChatMessage, error) {
+// JoinRoom adds a user to a room and returns its latest messages. Anyone may
+// join a public room, while invite-only and private rooms need an invite
+// token. Members rejoining need nothing, and banned users may not join.
+func (s *ChatService) JoinRoom(ctx context.Context, userId, roomId, invite string) ([]cicada.ChatMessage, error) {
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	r, err := s.rs.Get(roomId)
-	if err != nil {
+	if _, err := s.us.Get(userId); err != nil {
 		return nil, err
 	}
 
-	r.Members = append(r.Members, userId)
-	err = s.rs.Update(r)
+	r, err := s.rs.Get(roomId)
 	if err != nil {
 		return nil, err
 	}
 
-	go s.SendMessage(systemMessage(roomId, userId+" has joined"))
+	if !slices.Contains(r.Members, userId) {
+		if sa, banned, err := s.activeSanction(roomId, userId, cicada.SanctionBan); err != nil {
+			return nil, err
+		} else if banned {
+			return nil, bannedError(sa)
+		}
+		if err = s.checkAccess(r, userId, invite); err != nil {
+			return nil, err
+		}
+
+		r.Members = append(r.Members, userId)
+		err = s.rs.Update(r)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.us.AddRoom(userId, roomId)
+		if err != nil {
+			return nil, err
+		}
+
+		e := cicada.AuditEvent{Actor: userId, Action: cicada.AuditRoomJoin, RoomId: roomId}
+		if len(invite) != 0 {
+			e.Changes = map[string]string{"invite": invite}
+		}
+		s.audit(ctx, e)
+		if !r.Direct {
+			go s.announce(roomId, userId+" has joined")
+		}
+	}
 
-	return s.cs.GetWindow(roomId, 0, 100)
+	return s.cs.Page(roomId, chat.Cursor{}, chat.Before, s.opts.HistoryPageSize)
 }
 
-func (s *ChatService) LeaveRoom(userId, roomId string) error {
+// LeaveRoom removes a user from a room. A room left empty is deleted, unless
+// it is a direct conversation, and an owner who leaves is succeeded by
+// another member.
+func (s *ChatService) LeaveRoom(ctx context.Context, userId, roomId string) error {
 	s.m.Lock()
 	defer s.m.Unlock()
 
@@ -141,59 +479,319 @@ func (s *ChatService) LeaveRoom(userId, roomId string) error {
 	}
 
 	if !found {
-		return errors.New("member not in room")
+		return fmt.Errorf("%w: member not in room", cicada.ErrorBadRequest)
+	}
+	wasOwner := r.Roles[userId] == cicada.RoleOwner
+	delete(r.Roles, userId)
+	var heir string
+	if wasOwner {
+		heir = succeedOwner(&r)
+	}
+
+	err = s.us.RemoveRoom(userId, roomId)
+	if err != nil && !errors.Is(err, cicada.ErrorNotFound) {
+		return err
 	}
 
-	// if there are no more users in the room, delete the room and the chat associated with it
-	if len(r.Members) == 0 {
-		// TODO: how to handle this better?
-		_, err = s.rs.Get(roomId)
+	key := typingKey{roomId: roomId, userId: userId}
+	wasTyping := s.stopTyping(key)
+
+	// if there are no more users in the room, delete the room and the chat associated with it.
+	// A direct conversation is kept, since either user can open it again, and
+	// so is a room under legal hold.
+	if len(r.Members) == 0 && !r.Direct && !r.LegalHold {
+		var ids []string
+		ids, err = s.cs.ImageIds(roomId)
 		if err == nil {
 			err = s.cs.Delete(roomId)
-			if err == nil {
-				err = s.rs.Delete(roomId)
-			}
+		}
+		if err == nil {
+			s.releaseImages(ids)
+			s.unindexRoom(roomId)
+			err = s.rs.Delete(roomId)
+		}
+		if err == nil {
+			s.audit(ctx, cicada.AuditEvent{Actor: userId, Action: cicada.AuditRoomLeave, RoomId: roomId})
+			s.audit(ctx, cicada.AuditEvent{Actor: userId, Action: cicada.AuditRoomDelete, RoomId: roomId})
 		}
 	} else {
-		delete(s.clients, userId)
 		err = s.rs.Update(r)
-		go s.SendMessage(systemMessage(roomId, userId+" left the room")) // send notification
+		if err == nil {
+			e := cicada.AuditEvent{Actor: userId, Action: cicada.AuditRoomLeave, RoomId: roomId}
+			if len(heir) != 0 {
+				e.Changes = map[string]string{"owner": heir}
+			}
+			s.audit(ctx, e)
+		}
+		if err == nil && !r.Direct {
+			err = s.cs.DeleteReceipt(userId, roomId)
+		}
+		if wasTyping {
+			go s.stoppedTyping(key)
+		}
+		if !r.Direct {
+			go s.announce(roomId, userId+" left the room") // send notification
+		}
+		if len(heir) != 0 {
+			go s.announce(roomId, heir+" is now the owner")
+		}
 	}
 
 	return err
 }
 
+// CreateUser registers a new account. Room membership can only be changed by
+// joining or leaving rooms.
+func (s *ChatService) CreateUser(ctx context.Context, u cicada.User, password string) (cicada.User, error) {
+	if err := validateUserName(u.Name); err != nil {
+		return cicada.User{}, err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return cicada.User{}, err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err = s.nameAvailable(u.Name); err != nil {
+		return cicada.User{}, err
+	}
+
+	u.Rooms = []string{}
+	id, err := s.us.Put(u)
+	if err != nil {
+		return cicada.User{}, err
+	}
+	u.Id = id
+	if err = s.us.SetPassword(id, hash); err != nil {
+		return u, err
+	}
+	s.audit(ctx, cicada.AuditEvent{Actor: id, Action: cicada.AuditUserCreate, Target: id, Changes: map[string]string{"name": u.Name}})
+	return u, nil
+}
+
+// Authenticate checks a user's name and password.
+func (s *ChatService) Authenticate(name, password string) (cicada.User, error) {
+	// unknown names still pay for a hash comparison, so response times do not
+	// reveal which names are registered
+	u, err := s.us.GetByName(name)
+	if errors.Is(err, cicada.ErrorNotFound) {
+		return cicada.User{}, auth.RejectPassword(password)
+	} else if err != nil {
+		return cicada.User{}, err
+	}
+
+	hash, err := s.us.PasswordHash(u.Id)
+	if errors.Is(err, cicada.ErrorNotFound) {
+		return cicada.User{}, auth.RejectPassword(password)
+	} else if err != nil {
+		return cicada.User{}, err
+	}
+
+	return u, auth.CheckPassword(hash, password)
+}
+
+// SetPassword replaces a user's password.
+func (s *ChatService) SetPassword(ctx context.Context, userId, password string) error {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err = s.us.SetPassword(userId, hash); err != nil {
+		return err
+	}
+	s.audit(ctx, cicada.AuditEvent{Actor: userId, Action: cicada.AuditUserPassword, Target: userId})
+	return nil
+}
+
+func (s *ChatService) GetUser(userId string) (cicada.User, error) {
+	return s.us.Get(userId)
+}
+
+// RenameUser changes the display name of a user.
+func (s *ChatService) RenameUser(ctx context.Context, userId, name string) (cicada.User, error) {
+	if err := validateUserName(name); err != nil {
+		return cicada.User{}, err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	u, err := s.us.Get(userId)
+	if err != nil {
+		return cicada.User{}, err
+	}
+
+	if u.Name == name {
+		return u, nil
+	}
+
+	if err = s.nameAvailable(name); err != nil {
+		return cicada.User{}, err
+	}
+
+	u.Name = name
+	if err = s.us.Update(u); err != nil {
+		return u, err
+	}
+	s.audit(ctx, cicada.AuditEvent{Actor: userId, Action: cicada.AuditUserRename, Target: userId, Changes: map[string]string{"name": name}})
+	return u, nil
+}
+
+// DeleteUser removes a user from all of their rooms, closes their connection
+// and deletes them.
+func (s *ChatService) DeleteUser(ctx context.Context, userId string) error {
+	u, err := s.us.Get(userId)
+	if err != nil {
+		return err
+	}
+
+	for _, roomId := range u.Rooms {
+		err = s.LeaveRoom(ctx, userId, roomId)
+		if err != nil && !errors.Is(err, cicada.ErrorNotFound) && !errors.Is(err, cicada.ErrorBadRequest) {
+			return err
+		}
+	}
+
+	_ = s.Disconnect(userId)
+	s.markOffline(userId)
+	s.m.Lock()
+	delete(s.presence, userId)
+	s.m.Unlock()
+	if err = s.us.Delete(userId); err != nil {
+		return err
+	}
+	s.audit(ctx, cicada.AuditEvent{Actor: userId, Action: cicada.AuditUserDelete, Target: userId})
+	return nil
+}
+
+// broadcast delivers a frame to every connected member, skipping the user
+// named by except.
+func (s *ChatService) broadcast(members []string, except string, f []byte) {
+	s.m.Lock()
+	subs := make([]subscription, 0, len(members))
+	for _, uid := range members {
+		if uid == except {
+			continue
+		}
+		if sub, ok := s.clients[uid]; ok {
+			subs = append(subs, sub)
+		}
+	}
+	s.m.Unlock()
+
+	for _, sub := range subs {
+		s.send(sub, f)
+	}
+}
+
+func (s *ChatService) validateMessage(m cicada.ChatMessage) error {
+	if len(m.RoomId) == 0 {
+		return fmt.Errorf("%w: message has no room id", cicada.ErrorBadRequest)
+	}
+	if len(m.Sender) == 0 {
+		return fmt.Errorf("%w: message has no sender", cicada.ErrorBadRequest)
+	}
+	if len(strings.TrimSpace(m.Text)) == 0 && len(m.Images) == 0 {
+		return fmt.Errorf("%w: message is empty", cicada.ErrorBadRequest)
+	}
+	if utf8.RuneCountInString(m.Text) > s.opts.MaxMessageLength {
+		return fmt.Errorf("%w: message text is longer than %d characters", cicada.ErrorBadRequest, s.opts.MaxMessageLength)
+	}
+	if len(m.Images) > s.opts.MaxMessageImages {
+		return fmt.Errorf("%w: message has more than %d images", cicada.ErrorBadRequest, s.opts.MaxMessageImages)
+	}
+	return nil
+}
+
+// nameAvailable checks that no other user has the name.
+func (s *ChatService) nameAvailable(name string) error {
+	_, err := s.us.GetByName(name)
+	if err == nil {
+		return fmt.Errorf("%w: user name %s is taken", cicada.ErrorConflict, name)
+	} else if !errors.Is(err, cicada.ErrorNotFound) {
+		return err
+	}
+	return nil
+}
+
+func validateUserName(name string) error {
+	if len(strings.TrimSpace(name)) == 0 {
+		return fmt.Errorf("%w: user name is empty", cicada.ErrorBadRequest)
+	}
+	if utf8.RuneCountInString(name) > MaxUserNameLength {
+		return fmt.Errorf("%w: user name is longer than %d characters", cicada.ErrorBadRequest, MaxUserNameLength)
+	}
+	return nil
+}
+
+// uniqueMembers removes duplicate and empty user ids, preserving order.
+func uniqueMembers(members []string) []string {
+	unique := make([]string, 0, len(members))
+	for _, uid := range members {
+		if len(uid) != 0 && !slices.Contains(unique, uid) {
+			unique = append(unique, uid)
+		}
+	}
+	return unique
+}
+
+func imageIds(images []cicada.Image) []string {
+	ids := make([]string, len(images))
+	for i, img := range images {
+		ids[i] = img.Id
+	}
+	return ids
+}
+
+// systemSender is the sender of announcements made by the server.
+const systemSender = "system"
+
 func systemMessage(roomId, text string) cicada.ChatMessage {
 	return cicada.ChatMessage{
 		Id:     uuid.NewV4().String(),
 		Date:   time.Now(),
 		RoomId: roomId,
-		Sender: "system",
+		Sender: systemSender,
 		Text:   text,
 	}
 }
 
-func writeLoop(ch chan []byte, quit chan interface{}, ws *websocket.Conn) {
+// writeLoop writes frames queued for a connection until it closes. When a
+// replay is given, it is written before any live frame.
+func (s *ChatService) writeLoop(sub subscription, missed <-chan replay, ws *websocket.Conn) {
 	defer ws.CloseNow()
+	if missed != nil && !s.writeReplay(sub, missed, ws) {
+		return
+	}
 	for {
 		select {
-		case <-quit:
-			break
-		case mesg := <-ch:
-			err := messageWithTimeout(mesg, ws)
-			if err != nil {
-				break
+		case <-sub.quit:
+			return
+		case mesg := <-sub.replies:
+			if messageWithTimeout(mesg, ws, s.opts.WriteTimeout) != nil {
+				return
+			}
+		case mesg := <-sub.message:
+			// anything dropped was queued before mesg, so tell the client first
+			if f := sub.gap.take(); f != nil && messageWithTimeout(f, ws, s.opts.WriteTimeout) != nil {
+				return
+			}
+			if messageWithTimeout(mesg, ws, s.opts.WriteTimeout) != nil {
+				return
 			}
 		}
 	}
 }
 
-func messageWithTimeout(mesg []byte, ws *websocket.Conn) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func messageWithTimeout(mesg []byte, ws *websocket.Conn, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	err := ws.Write(ctx, websocket.MessageText, mesg)
 	if err != nil {
-		slog.Error("error writing to client", err)
+		slog.Error("error writing to client", "error", err)
 	}
 	return err
 }
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"cicada"
+	"cicada/internal/server/store/audit"
+	"cicada/internal/server/store/chat"
+	"cicada/internal/server/store/image"
+	"cicada/internal/server/store/room"
+	"cicada/internal/server/store/search"
+	"cicada/internal/server/store/user"
+	"context"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/ostafen/clover/v2"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// service starts a chat service on databases in a fresh temporary directory.
+// The returned function closes the databases and removes the directory.
+func service(opts Options) (*ChatService, func()) {
+	dir, err := os.MkdirTemp("", "cicada-server")
+	if err != nil {
+		log.Fatal("unable to create temp dir", err)
+	}
+
+	objDb, err := clover.Open(dir)
+	if err != nil {
+		log.Fatal("unable to open clover database", err)
+	}
+	kvDb, err := badger.Open(badger.DefaultOptions(filepath.Join(dir, "images")).WithLogger(nil))
+	if err != nil {
+		log.Fatal("unable to open image database", err)
+	}
+	searchDb, err := badger.Open(badger.DefaultOptions(filepath.Join(dir, "search")).WithLogger(nil))
+	if err != nil {
+		log.Fatal("unable to open search database", err)
+	}
+
+	quit := make(chan interface{})
+	s := New(quit, opts, chat.NewStore(objDb), room.NewStore(objDb), user.NewStore(objDb), image.NewStore(kvDb), search.NewStore(searchDb), audit.NewStore(objDb))
+	return s, func() {
+		close(quit)
+		searchDb.Close()
+		kvDb.Close()
+		objDb.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// newUser creates a user with a valid password and returns their id.
+func newUser(s *ChatService, name string) string {
+	u, err := s.CreateUser(context.Background(), cicada.User{Name: name}, "password1")
+	if err != nil {
+		log.Fatal("unable to create user", name, err)
+	}
+	return u.Id
+}
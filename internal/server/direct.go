@@ -0,0 +1,79 @@
+package server
+
+import (
+	"cicada"
+	"context"
+	"errors"
+	"fmt"
+	uuid "github.com/satori/go.uuid"
+	"slices"
+)
+
+// directNamespace derives the id of the direct conversation between two
+// users, so each pair has exactly one.
+var directNamespace = uuid.Must(uuid.FromString("3b9a7c52-8f0e-4d6a-b1c4-0e5d2f7a9c83"))
+
+// OpenDirect returns the direct conversation between a user and another,
+// creating it the first time. Opening it again always returns the same room,
+// and brings back either user who had left it.
+func (s *ChatService) OpenDirect(ctx context.Context, userId, otherId string) (cicada.Room, error) {
+	if userId == otherId {
+		return cicada.Room{}, fmt.Errorf("%w: a direct conversation needs two users", cicada.ErrorBadRequest)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, uid := range []string{userId, otherId} {
+		if _, err := s.us.Get(uid); errors.Is(err, cicada.ErrorNotFound) {
+			return cicada.Room{}, fmt.Errorf("%w: unknown user %s", cicada.ErrorBadRequest, uid)
+		} else if err != nil {
+			return cicada.Room{}, err
+		}
+	}
+
+	participants := []string{userId, otherId}
+	slices.Sort(participants)
+	id := directId(participants)
+
+	r, err := s.rs.Get(id)
+	changed := true
+	if errors.Is(err, cicada.ErrorNotFound) {
+		r = cicada.Room{
+			Id:           id,
+			Members:      participants,
+			Visibility:   cicada.VisibilityPrivate,
+			Direct:       true,
+			Participants: participants,
+		}
+		if err = s.rs.PutDirect(r); err != nil {
+			return cicada.Room{}, err
+		}
+	} else if err != nil {
+		return cicada.Room{}, err
+	} else if !r.Direct || !slices.Equal(r.Participants, participants) {
+		return cicada.Room{}, fmt.Errorf("%w: room %s is not the direct conversation of %s and %s", cicada.ErrorConflict, id, userId, otherId)
+	} else if len(r.Members) != len(participants) {
+		r.Members = participants
+		if err = s.rs.Update(r); err != nil {
+			return cicada.Room{}, err
+		}
+	} else {
+		changed = false
+	}
+
+	for _, uid := range participants {
+		if err = s.us.AddRoom(uid, id); err != nil {
+			return cicada.Room{}, err
+		}
+	}
+	if changed {
+		s.audit(ctx, cicada.AuditEvent{Actor: userId, Action: cicada.AuditDirectOpen, RoomId: id, Target: otherId})
+	}
+	return r, nil
+}
+
+// directId derives the room id for a sorted pair of users.
+func directId(participants []string) string {
+	return uuid.NewV5(directNamespace, participants[0]+"/"+participants[1]).String()
+}
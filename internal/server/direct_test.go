@@ -0,0 +1,42 @@
+package server
+
+import (
+	"cicada"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestDirectHijack(t *testing.T) {
+	s, done := service(DefaultOptions())
+	defer done()
+	ctx := context.Background()
+
+	alice, bob, eve := newUser(s, "alice"), newUser(s, "bob"), newUser(s, "eve")
+	participants := []string{alice, bob}
+	slices.Sort(participants)
+	id := directId(participants)
+
+	r, err := s.CreateRoom(ctx, eve, cicada.Room{Id: id, Name: "Trap", LegalHold: true, Retention: &cicada.Retention{MaxMessages: 1}})
+	if err != nil {
+		t.Fatal("unable to create room", err)
+	}
+	if r.Id == id {
+		t.Fatal("expected the room to be given a new id")
+	}
+	if r.LegalHold || r.Retention != nil {
+		t.Error("expected legal hold and retention to be ignored, got", r)
+	}
+
+	direct, err := s.OpenDirect(ctx, alice, bob)
+	if err != nil {
+		t.Fatal("unable to open direct conversation", err)
+	}
+	if direct.Id != id || !direct.Direct || slices.Contains(direct.Members, eve) {
+		t.Fatal("expected a new direct conversation, got", direct)
+	}
+	if _, err = s.JoinRoom(ctx, eve, id, ""); !errors.Is(err, cicada.ErrorNotFound) && !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected eve not to join the direct conversation, got", err)
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"cicada"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// EditMessage replaces the text of a message. The sender may edit it, as
+// may a moderator who outranks them, but announcements from the server may
+// not be edited. The previous text is kept as a revision, and the edited
+// message is pushed to the room.
+func (s *ChatService) EditMessage(ctx context.Context, userId, messageId, text string) (cicada.ChatMessage, error) {
+	s.m.Lock()
+	r, m, err := s.modifiable(userId, messageId, PermEditMessages)
+	if err != nil {
+		s.m.Unlock()
+		return cicada.ChatMessage{}, err
+	}
+
+	if m.Sender == systemSender {
+		s.m.Unlock()
+		return cicada.ChatMessage{}, fmt.Errorf("%w: announcements can not be edited", cicada.ErrorForbidden)
+	}
+
+	if m.Deleted {
+		s.m.Unlock()
+		return cicada.ChatMessage{}, fmt.Errorf("%w: message %s has been deleted", cicada.ErrorConflict, messageId)
+	}
+	if len(strings.TrimSpace(text)) == 0 && len(m.Images) == 0 {
+		s.m.Unlock()
+		return cicada.ChatMessage{}, fmt.Errorf("%w: message is empty", cicada.ErrorBadRequest)
+	}
+	if utf8.RuneCountInString(text) > s.opts.MaxMessageLength {
+		s.m.Unlock()
+		return cicada.ChatMessage{}, fmt.Errorf("%w: message text is longer than %d characters", cicada.ErrorBadRequest, s.opts.MaxMessageLength)
+	}
+	if text == m.Text {
+		s.m.Unlock()
+		return m, nil
+	}
+
+	written := m.Date
+	if m.EditedAt != nil {
+		written = *m.EditedAt
+	}
+	err = s.cs.SaveRevision(cicada.Revision{MessageId: m.Id, RoomId: m.RoomId, Text: m.Text, Date: written})
+	if err == nil {
+		now := time.Now()
+		m.Text = text
+		m.EditedAt = &now
+		err = s.cs.Replace(m)
+	}
+	s.m.Unlock()
+
+	if err != nil {
+		return cicada.ChatMessage{}, err
+	}
+	s.audit(ctx, cicada.AuditEvent{Actor: userId, Action: cicada.AuditMessageEdit, RoomId: m.RoomId, Target: m.Id})
+	s.index(m)
+	s.pushChange(r, cicada.FrameEdited, m)
+	return m, nil
+}
+
+// DeleteMessage replaces a message with a tombstone, so the room's history
+// still shows where it was. Only the sender, or a member allowed to delete
+// the messages of others who outranks the sender, may delete it. Its images,
+// reactions and revisions are dropped.
+func (s *ChatService) DeleteMessage(ctx context.Context, userId, messageId string) (cicada.ChatMessage, error) {
+	s.m.Lock()
+	r, m, err := s.modifiable(userId, messageId, PermDeleteMessages)
+	if err != nil || m.Deleted {
+		// deleting a tombstone again changes nothing
+		s.m.Unlock()
+		return m, err
+	}
+
+	ids := imageIds(m.Images)
+	sender := m.Sender
+	now := time.Now()
+	m.Text = ""
+	m.Images = nil
+	m.Reactions = nil
+	m.ReactionCounts = nil
+	m.Deleted = true
+	m.EditedAt = &now
+	err = s.cs.Replace(m)
+	if err == nil {
+		err = s.cs.DeleteRevisions(m.Id)
+	}
+	s.m.Unlock()
+
+	if err != nil {
+		return cicada.ChatMessage{}, err
+	}
+	s.audit(ctx, cicada.AuditEvent{Actor: userId, Action: cicada.AuditMessageDelete, RoomId: m.RoomId, Target: m.Id, Changes: map[string]string{"sender": sender}})
+	s.releaseImages(ids)
+	s.index(m)
+	s.pushChange(r, cicada.FrameDeleted, m)
+	return m, nil
+}
+
+// Revisions lists the earlier texts of a message for a member of its room.
+func (s *ChatService) Revisions(userId, messageId string) ([]cicada.Revision, error) {
+	m, err := s.cs.Get(messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := s.rs.Get(m.RoomId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(r.Members, userId) {
+		return nil, fmt.Errorf("%w: %s is not a member of room %s", cicada.ErrorForbidden, userId, r.Id)
+	}
+	return s.cs.Revisions(messageId)
+}
+
+// modifiable fetches a message along with its room, and checks that a user
+// may change it. Members change their own messages with PermPost, and the
+// messages of those they outrank with the given permission. Callers must
+// hold the service lock.
+func (s *ChatService) modifiable(userId, messageId string, others Permission) (cicada.Room, cicada.ChatMessage, error) {
+	m, err := s.cs.Get(messageId)
+	if err != nil {
+		return cicada.Room{}, cicada.ChatMessage{}, err
+	}
+
+	r, err := s.rs.Get(m.RoomId)
+	if err != nil {
+		return cicada.Room{}, cicada.ChatMessage{}, err
+	}
+
+	if m.Sender == userId {
+		err = s.authorize(r, userId, PermPost)
+	} else {
+		err = s.authorizeAbove(r, userId, m.Sender, others)
+	}
+	if err != nil {
+		return cicada.Room{}, cicada.ChatMessage{}, err
+	}
+	return r, m, nil
+}
+
+// pushChange sends an edited or deleted message to the connected members of
+// its room.
+func (s *ChatService) pushChange(r cicada.Room, frameType string, m cicada.ChatMessage) {
+	f, err := newFrame(frameType, "", m)
+	if err != nil {
+		slog.Error("unable to push message change", "room", r.Id, "message", m.Id, "error", err)
+		return
+	}
+	s.broadcast(r.Members, "", f)
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"cicada"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestModifyRank(t *testing.T) {
+	s, done := service(DefaultOptions())
+	defer done()
+	ctx := context.Background()
+
+	owner, moderator, member := newUser(s, "owner"), newUser(s, "moderator"), newUser(s, "member")
+	r := newRoom(t, s, owner, moderator, member)
+	if _, err := s.SetRole(ctx, owner, r.Id, moderator, cicada.RoleModerator); err != nil {
+		t.Fatal("unable to promote moderator", err)
+	}
+
+	send := func(sender string) cicada.ChatMessage {
+		m, err := s.SendMessage(cicada.ChatMessage{RoomId: r.Id, Sender: sender, Text: "hello"})
+		if err != nil {
+			t.Fatal("unable to send message", err)
+		}
+		return m
+	}
+	fromOwner, fromModerator, fromMember := send(owner), send(moderator), send(member)
+
+	if _, err := s.EditMessage(ctx, member, fromModerator.Id, "edited"); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected a member not to edit others' messages, got", err)
+	}
+	if m, err := s.EditMessage(ctx, moderator, fromMember.Id, "edited"); err != nil || m.Text != "edited" {
+		t.Error("expected a moderator to edit a member's message, got", m, err)
+	}
+	if _, err := s.EditMessage(ctx, moderator, fromOwner.Id, "edited"); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected a moderator not to edit the owner's message, got", err)
+	}
+	if _, err := s.DeleteMessage(ctx, moderator, fromOwner.Id); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected a moderator not to delete the owner's message, got", err)
+	}
+	if m, err := s.DeleteMessage(ctx, owner, fromModerator.Id); err != nil || !m.Deleted {
+		t.Error("expected the owner to delete a moderator's message, got", m, err)
+	}
+
+	// once the sender has left they no longer outrank anyone
+	if err := s.LeaveRoom(ctx, owner, r.Id); err != nil {
+		t.Fatal("unable to leave room", err)
+	}
+	if m, err := s.DeleteMessage(ctx, moderator, fromOwner.Id); err != nil || !m.Deleted {
+		t.Error("expected a moderator to delete a former member's message, got", m, err)
+	}
+}
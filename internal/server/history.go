@@ -0,0 +1,178 @@
+package server
+
+import (
+	"cicada"
+	"cicada/internal/server/store/chat"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Anchor selects which part of a room's history to read.
+type Anchor int
+
+const (
+	// Latest reads back from the newest message.
+	Latest Anchor = iota
+	// Before reads messages older than the anchor.
+	Before
+	// After reads messages newer than the anchor.
+	After
+	// Around reads messages on both sides of the anchor, including it.
+	Around
+)
+
+// History returns a page of a room's main timeline, newest first, with a
+// summary of the replies to each message that has any. The position is
+// either a cursor from a previous page or a message id, and is ignored when
+// reading the latest messages.
+func (s *ChatService) History(userId, roomId string, anchor Anchor, position string, limit int) (cicada.HistoryPage, error) {
+	if limit <= 0 {
+		limit = s.opts.HistoryPageSize
+	}
+	if limit > s.opts.MaxHistoryPageSize {
+		return cicada.HistoryPage{}, fmt.Errorf("%w: limit must be at most %d", cicada.ErrorBadRequest, s.opts.MaxHistoryPageSize)
+	}
+
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return cicada.HistoryPage{}, err
+	}
+
+	if !slices.Contains(r.Members, userId) {
+		return cicada.HistoryPage{}, fmt.Errorf("%w: %s is not a member of room %s", cicada.ErrorForbidden, userId, roomId)
+	}
+
+	c := chat.Cursor{}
+	if anchor != Latest {
+		c, err = s.resolveCursor(roomId, position)
+		if err != nil {
+			return cicada.HistoryPage{}, err
+		}
+	}
+
+	var page cicada.HistoryPage
+	switch anchor {
+	case Latest, Before:
+		page, err = s.pageBefore(roomId, c, limit, nil)
+	case After:
+		page, err = s.pageAfter(roomId, c, limit)
+	case Around:
+		page, err = s.pageAround(roomId, c, limit)
+	default:
+		err = fmt.Errorf("%w: unknown history anchor", cicada.ErrorBadRequest)
+	}
+
+	if err == nil {
+		err = s.summariseThreads(&page)
+	}
+	if err != nil {
+		return cicada.HistoryPage{}, err
+	}
+	return page, nil
+}
+
+// resolveCursor turns a cursor token or a message id into a cursor within
+// the room.
+func (s *ChatService) resolveCursor(roomId, position string) (chat.Cursor, error) {
+	if len(position) == 0 {
+		return chat.Cursor{}, fmt.Errorf("%w: missing history anchor", cicada.ErrorBadRequest)
+	}
+
+	c, err := chat.ParseCursor(position)
+	if err == nil {
+		return c, nil
+	}
+
+	m, err := s.cs.Get(position)
+	if errors.Is(err, cicada.ErrorNotFound) {
+		return chat.Cursor{}, fmt.Errorf("%w: %s is not a cursor or message id", cicada.ErrorBadRequest, position)
+	} else if err != nil {
+		return chat.Cursor{}, err
+	}
+
+	if m.RoomId != roomId {
+		return chat.Cursor{}, fmt.Errorf("%w: message %s is not in room %s", cicada.ErrorBadRequest, position, roomId)
+	}
+	return chat.CursorOf(m), nil
+}
+
+// pageBefore reads up to limit messages older than c, prefixed by newer, which
+// must already be newest first. One extra message is read to learn whether an
+// older page exists.
+func (s *ChatService) pageBefore(roomId string, c chat.Cursor, limit int, newer []cicada.ChatMessage) (cicada.HistoryPage, error) {
+	older, err := s.cs.Page(roomId, c, chat.Before, limit+1)
+	if err != nil {
+		return cicada.HistoryPage{}, err
+	}
+
+	more := len(older) > limit
+	if more {
+		older = older[:limit]
+	}
+
+	page := cicada.HistoryPage{Messages: append(newer, older...)}
+	if more && len(page.Messages) > 0 {
+		page.Before = chat.CursorOf(page.Messages[len(page.Messages)-1]).String()
+	}
+	if !c.IsZero() && len(newer) == 0 && len(page.Messages) > 0 {
+		page.After = chat.CursorOf(page.Messages[0]).String()
+	}
+	return page, nil
+}
+
+// pageAfter reads up to limit messages newer than c.
+func (s *ChatService) pageAfter(roomId string, c chat.Cursor, limit int) (cicada.HistoryPage, error) {
+	newer, err := s.cs.Page(roomId, c, chat.After, limit+1)
+	if err != nil {
+		return cicada.HistoryPage{}, err
+	}
+
+	page := cicada.HistoryPage{}
+	if len(newer) > limit {
+		newer = newer[:limit]
+		page.After = chat.CursorOf(newer[limit-1]).String()
+	}
+
+	slices.Reverse(newer)
+	page.Messages = newer
+	if len(newer) > 0 {
+		page.Before = chat.CursorOf(newer[len(newer)-1]).String()
+	}
+	return page, nil
+}
+
+// pageAround reads the message at c with the rest of the limit split between
+// the messages either side of it.
+func (s *ChatService) pageAround(roomId string, c chat.Cursor, limit int) (cicada.HistoryPage, error) {
+	anchor, err := s.cs.Get(c.Id)
+	if err != nil {
+		return cicada.HistoryPage{}, err
+	}
+	if anchor.RoomId != roomId {
+		return cicada.HistoryPage{}, fmt.Errorf("%w: message %s is not in room %s", cicada.ErrorBadRequest, c.Id, roomId)
+	}
+
+	n := (limit - 1) / 2
+	newer, err := s.cs.Page(roomId, c, chat.After, n+1)
+	if err != nil {
+		return cicada.HistoryPage{}, err
+	}
+
+	moreNewer := len(newer) > n
+	if moreNewer {
+		newer = newer[:n]
+	}
+	slices.Reverse(newer)
+
+	page, err := s.pageBefore(roomId, c, limit-1-len(newer), append(newer, anchor))
+	if err != nil {
+		return cicada.HistoryPage{}, err
+	}
+
+	page.After = ""
+	if moreNewer {
+		page.After = chat.CursorOf(page.Messages[0]).String()
+	}
+	return page, nil
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"cicada"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendMessageValidation(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxMessageLength = 10
+	opts.MaxMessageImages = 1
+	s, done := service(opts)
+	defer done()
+
+	owner, outsider := newUser(s, "owner"), newUser(s, "outsider")
+	r := newRoom(t, s, owner)
+
+	tests := []struct {
+		name string
+		m    cicada.ChatMessage
+		err  error
+	}{
+		{"no room", cicada.ChatMessage{Sender: owner, Text: "hi"}, cicada.ErrorBadRequest},
+		{"no sender", cicada.ChatMessage{RoomId: r.Id, Text: "hi"}, cicada.ErrorBadRequest},
+		{"empty", cicada.ChatMessage{RoomId: r.Id, Sender: owner}, cicada.ErrorBadRequest},
+		{"blank", cicada.ChatMessage{RoomId: r.Id, Sender: owner, Text: " \n\t"}, cicada.ErrorBadRequest},
+		{"too long", cicada.ChatMessage{RoomId: r.Id, Sender: owner, Text: strings.Repeat("a", 11)}, cicada.ErrorBadRequest},
+		{"too many images", cicada.ChatMessage{RoomId: r.Id, Sender: owner, Images: []cicada.Image{{Id: "a"}, {Id: "b"}}}, cicada.ErrorBadRequest},
+		{"unknown image", cicada.ChatMessage{RoomId: r.Id, Sender: owner, Images: []cicada.Image{{Id: strings.Repeat("0", 64)}}}, cicada.ErrorBadRequest},
+		{"missing room", cicada.ChatMessage{RoomId: "nowhere", Sender: owner, Text: "hi"}, cicada.ErrorNotFound},
+		{"not a member", cicada.ChatMessage{RoomId: r.Id, Sender: outsider, Text: "hi"}, cicada.ErrorForbidden},
+		{"longest", cicada.ChatMessage{RoomId: r.Id, Sender: owner, Text: strings.Repeat("é", 10)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.SendMessage(tt.m)
+			if tt.err == nil && err != nil {
+				t.Error("expected the message to be sent, got", err)
+			} else if !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSendMessageAssigned(t *testing.T) {
+	s, done := service(DefaultOptions())
+	defer done()
+
+	owner := newUser(s, "owner")
+	r := newRoom(t, s, owner)
+
+	// the id and date are the server's, not the client's
+	before := time.Now()
+	sent := cicada.ChatMessage{Id: "mine", Date: before.Add(-time.Hour), RoomId: r.Id, Sender: owner, Text: "hi"}
+	m, err := s.SendMessage(sent)
+	if err != nil {
+		t.Fatal("unable to send message", err)
+	}
+	if len(m.Id) == 0 || m.Id == sent.Id {
+		t.Error("expected a server assigned id, got", m.Id)
+	}
+	if m.Date.Before(before) || m.Date.After(time.Now()) {
+		t.Error("expected a server assigned date, got", m.Date)
+	}
+
+	stored, err := s.cs.Get(m.Id)
+	if err != nil {
+		t.Fatal("unable to read sent message", err)
+	}
+	if stored.Text != "hi" || stored.Sender != owner || !stored.Date.Equal(m.Date) {
+		t.Error("expected the acknowledged message to be stored, got", stored)
+	}
+
+	again, err := s.SendMessage(sent)
+	if err != nil || again.Id == m.Id {
+		t.Error("expected every message to get its own id, got", again.Id, err)
+	}
+}
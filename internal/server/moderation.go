@@ -0,0 +1,297 @@
+package server
+
+import (
+	"cicada"
+	"context"
+	"errors"
+	"fmt"
+	uuid "github.com/satori/go.uuid"
+	"slices"
+	"time"
+)
+
+// Kick removes a member from a room. They may join again as anyone else
+// would.
+func (s *ChatService) Kick(ctx context.Context, userId, roomId, targetId, reason string) (cicada.Sanction, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return cicada.Sanction{}, err
+	}
+
+	if err = s.authorizeOver(r, userId, targetId, PermKick); err != nil {
+		return cicada.Sanction{}, err
+	}
+
+	sa, err := s.sanction(ctx, r, userId, targetId, cicada.SanctionKick, reason, 0)
+	if err != nil {
+		return cicada.Sanction{}, err
+	}
+	if err = s.evict(r, targetId); err != nil {
+		return cicada.Sanction{}, err
+	}
+	go s.announce(roomId, targetId+" was removed by "+userId+because(reason))
+	return sa, nil
+}
+
+// Ban removes a user from a room and keeps them out until the ban expires or
+// is revoked. A zero duration bans them until it is revoked. Users who have
+// already left can be banned too.
+func (s *ChatService) Ban(ctx context.Context, userId, roomId, targetId, reason string, d time.Duration) (cicada.Sanction, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return cicada.Sanction{}, err
+	}
+
+	member := slices.Contains(r.Members, targetId)
+	if member {
+		err = s.authorizeOver(r, userId, targetId, PermBan)
+	} else if err = s.authorize(r, userId, PermBan); err == nil {
+		if _, err = s.us.Get(targetId); errors.Is(err, cicada.ErrorNotFound) {
+			err = fmt.Errorf("%w: unknown user %s", cicada.ErrorBadRequest, targetId)
+		}
+	}
+	if err != nil {
+		return cicada.Sanction{}, err
+	}
+
+	sa, err := s.sanction(ctx, r, userId, targetId, cicada.SanctionBan, reason, d)
+	if err != nil {
+		return cicada.Sanction{}, err
+	}
+	if member {
+		if err = s.evict(r, targetId); err != nil {
+			return cicada.Sanction{}, err
+		}
+	}
+	go s.announce(roomId, targetId+" was banned by "+userId+until(sa)+because(reason))
+	return sa, nil
+}
+
+// Mute stops a member from posting to a room, while still letting them read
+// it, until the mute expires or is revoked. A zero duration mutes them until
+// it is revoked.
+func (s *ChatService) Mute(ctx context.Context, userId, roomId, targetId, reason string, d time.Duration) (cicada.Sanction, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return cicada.Sanction{}, err
+	}
+
+	if err = s.authorizeOver(r, userId, targetId, PermMute); err != nil {
+		return cicada.Sanction{}, err
+	}
+
+	sa, err := s.sanction(ctx, r, userId, targetId, cicada.SanctionMute, reason, d)
+	if err != nil {
+		return cicada.Sanction{}, err
+	}
+	go s.announce(roomId, targetId+" was muted by "+userId+until(sa)+because(reason))
+	return sa, nil
+}
+
+// Revoke undoes a sanction. Lifting a ban or mute takes effect at once, while
+// revoking a kick lets the user back into the room. Revoking needs the same
+// permission as taking the action, and only whoever took it or a member who
+// outranks them may revoke it.
+func (s *ChatService) Revoke(ctx context.Context, userId, roomId, sanctionId string) (cicada.Sanction, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	sa, err := s.rs.GetSanction(sanctionId)
+	if err != nil {
+		return cicada.Sanction{}, err
+	}
+	if sa.RoomId != roomId {
+		return cicada.Sanction{}, fmt.Errorf("%w: no sanction %s in room %s", cicada.ErrorNotFound, sanctionId, roomId)
+	}
+
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return cicada.Sanction{}, err
+	}
+
+	if sa.By == userId {
+		err = s.authorize(r, userId, sanctionPermission[sa.Action])
+	} else {
+		err = s.authorizeAbove(r, userId, sa.By, sanctionPermission[sa.Action])
+	}
+	if err != nil {
+		return cicada.Sanction{}, err
+	}
+
+	now := time.Now()
+	if sa.Revoked != nil {
+		return cicada.Sanction{}, fmt.Errorf("%w: sanction %s has already been revoked", cicada.ErrorConflict, sanctionId)
+	}
+	if sa.Action != cicada.SanctionKick && !sa.Active(now) {
+		return cicada.Sanction{}, fmt.Errorf("%w: sanction %s has expired", cicada.ErrorConflict, sanctionId)
+	}
+
+	var text string
+	switch sa.Action {
+	case cicada.SanctionKick:
+		if err = s.readmit(r, sa.UserId); err != nil {
+			return cicada.Sanction{}, err
+		}
+		text = sa.UserId + " was let back in by " + userId
+	case cicada.SanctionBan:
+		text = userId + " lifted the ban on " + sa.UserId
+	case cicada.SanctionMute:
+		text = userId + " unmuted " + sa.UserId
+	}
+
+	sa.Revoked = &now
+	sa.RevokedBy = userId
+	if err = s.rs.ReplaceSanction(sa); err != nil {
+		return cicada.Sanction{}, err
+	}
+	s.audit(ctx, cicada.AuditEvent{Actor: userId, Action: cicada.AuditSanctionRevoke, RoomId: roomId, Target: sa.UserId, Changes: map[string]string{"sanction": sa.Id}})
+	go s.announce(roomId, text)
+	return sa, nil
+}
+
+// Sanctions lists the moderation actions taken in a room, oldest first. Only
+// members who may kick can see them.
+func (s *ChatService) Sanctions(userId, roomId string) ([]cicada.Sanction, error) {
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.authorize(r, userId, PermKick); err != nil {
+		return nil, err
+	}
+	return s.rs.Sanctions(roomId, "")
+}
+
+// sanctionPermission is the permission needed to take or revoke each action.
+var sanctionPermission = map[string]Permission{
+	cicada.SanctionKick: PermKick,
+	cicada.SanctionBan:  PermBan,
+	cicada.SanctionMute: PermMute,
+}
+
+// sanction records a moderation action, and audits it.
+func (s *ChatService) sanction(ctx context.Context, r cicada.Room, userId, targetId, action, reason string, d time.Duration) (cicada.Sanction, error) {
+	if d < 0 {
+		return cicada.Sanction{}, fmt.Errorf("%w: duration must not be negative", cicada.ErrorBadRequest)
+	}
+
+	sa := cicada.Sanction{
+		Id:     uuid.NewV4().String(),
+		RoomId: r.Id,
+		UserId: targetId,
+		Action: action,
+		By:     userId,
+		Reason: reason,
+		Date:   time.Now(),
+	}
+	if d > 0 {
+		expires := sa.Date.Add(d)
+		sa.Expires = &expires
+	}
+
+	if err := s.rs.PutSanction(sa); err != nil {
+		return cicada.Sanction{}, err
+	}
+	s.audit(ctx, cicada.AuditEvent{Actor: userId, Action: cicada.AuditSanction, RoomId: r.Id, Target: targetId, Changes: sanctionChanges(sa)})
+	return sa, nil
+}
+
+// sanctionChanges describes a sanction for the audit log.
+func sanctionChanges(sa cicada.Sanction) map[string]string {
+	changes := map[string]string{"sanction": sa.Id, "action": sa.Action}
+	if len(sa.Reason) != 0 {
+		changes["reason"] = sa.Reason
+	}
+	if sa.Expires != nil {
+		changes["expires"] = sa.Expires.UTC().Format(time.RFC3339)
+	}
+	return changes
+}
+
+// activeSanction finds the latest ban or mute of a user in a room that is
+// still in force, if there is one.
+func (s *ChatService) activeSanction(roomId, userId, action string) (cicada.Sanction, bool, error) {
+	list, err := s.rs.Sanctions(roomId, userId)
+	if err != nil {
+		return cicada.Sanction{}, false, err
+	}
+
+	now := time.Now()
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i].Action == action && list[i].Active(now) {
+			return list[i], true, nil
+		}
+	}
+	return cicada.Sanction{}, false, nil
+}
+
+// evict removes a member from a room on behalf of a moderator. Unlike
+// LeaveRoom, the room is never left empty, since the moderator stays.
+// Callers must hold the service lock.
+func (s *ChatService) evict(r cicada.Room, userId string) error {
+	r.Members = slices.DeleteFunc(r.Members, func(uid string) bool { return uid == userId })
+	delete(r.Roles, userId)
+	if err := s.rs.Update(r); err != nil {
+		return err
+	}
+
+	err := s.us.RemoveRoom(userId, r.Id)
+	if err != nil && !errors.Is(err, cicada.ErrorNotFound) {
+		return err
+	}
+
+	key := typingKey{roomId: r.Id, userId: userId}
+	if s.stopTyping(key) {
+		go s.stoppedTyping(key)
+	}
+	return s.cs.DeleteReceipt(userId, r.Id)
+}
+
+// readmit puts a kicked user back into a room, unless they have since been
+// banned or have joined again. Callers must hold the service lock.
+func (s *ChatService) readmit(r cicada.Room, userId string) error {
+	if slices.Contains(r.Members, userId) {
+		return nil
+	}
+
+	if sa, banned, err := s.activeSanction(r.Id, userId, cicada.SanctionBan); err != nil {
+		return err
+	} else if banned {
+		return bannedError(sa)
+	}
+
+	r.Members = append(r.Members, userId)
+	if err := s.rs.Update(r); err != nil {
+		return err
+	}
+	return s.us.AddRoom(userId, r.Id)
+}
+
+func bannedError(sa cicada.Sanction) error {
+	return fmt.Errorf("%w: %s is banned from room %s%s", cicada.ErrorForbidden, sa.UserId, sa.RoomId, until(sa))
+}
+
+// until describes when a sanction expires, if it does.
+func until(sa cicada.Sanction) string {
+	if sa.Expires == nil {
+		return ""
+	}
+	return " until " + sa.Expires.UTC().Format(time.RFC3339)
+}
+
+func because(reason string) string {
+	if len(reason) == 0 {
+		return ""
+	}
+	return ": " + reason
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"cicada"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestKickRank(t *testing.T) {
+	s, done := service(DefaultOptions())
+	defer done()
+	ctx := context.Background()
+
+	owner, first, second, member := newUser(s, "owner"), newUser(s, "first"), newUser(s, "second"), newUser(s, "member")
+	r := newRoom(t, s, owner, first, second, member)
+	for _, uid := range []string{first, second} {
+		if _, err := s.SetRole(ctx, owner, r.Id, uid, cicada.RoleModerator); err != nil {
+			t.Fatal("unable to promote moderator", err)
+		}
+	}
+
+	if _, err := s.Kick(ctx, first, r.Id, owner, ""); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected a moderator not to kick the owner, got", err)
+	}
+	if _, err := s.Ban(ctx, first, r.Id, second, "", 0); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected a moderator not to ban another moderator, got", err)
+	}
+	if _, err := s.Kick(ctx, member, r.Id, first, ""); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected a member not to kick, got", err)
+	}
+	if _, err := s.Kick(ctx, first, r.Id, member, ""); err != nil {
+		t.Error("expected a moderator to kick a member, got", err)
+	}
+}
+
+func TestRevokeRank(t *testing.T) {
+	s, done := service(DefaultOptions())
+	defer done()
+	ctx := context.Background()
+
+	owner, first, second, member := newUser(s, "owner"), newUser(s, "first"), newUser(s, "second"), newUser(s, "member")
+	r := newRoom(t, s, owner, first, second, member)
+	for _, uid := range []string{first, second} {
+		if _, err := s.SetRole(ctx, owner, r.Id, uid, cicada.RoleModerator); err != nil {
+			t.Fatal("unable to promote moderator", err)
+		}
+	}
+
+	ban, err := s.Ban(ctx, owner, r.Id, member, "", 0)
+	if err != nil {
+		t.Fatal("unable to ban member", err)
+	}
+	if _, err = s.Revoke(ctx, first, r.Id, ban.Id); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected a moderator not to lift the owner's ban, got", err)
+	}
+	if _, err = s.JoinRoom(ctx, member, r.Id, ""); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected the ban to keep the member out, got", err)
+	}
+
+	third := newUser(s, "third")
+	if _, err = s.JoinRoom(ctx, third, r.Id, ""); err != nil {
+		t.Fatal("unable to join room", err)
+	}
+	mute, err := s.Mute(ctx, second, r.Id, third, "", 0)
+	if err != nil {
+		t.Fatal("unable to mute member", err)
+	}
+	if _, err = s.Revoke(ctx, first, r.Id, mute.Id); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected a moderator not to lift a peer's mute, got", err)
+	}
+	if _, err = s.Revoke(ctx, owner, r.Id, mute.Id); err != nil {
+		t.Error("expected the owner to lift a moderator's mute, got", err)
+	}
+	if _, err = s.Revoke(ctx, owner, r.Id, ban.Id); err != nil {
+		t.Error("expected the owner to lift their own ban, got", err)
+	}
+	if _, err = s.JoinRoom(ctx, member, r.Id, ""); err != nil {
+		t.Error("expected the member to rejoin once the ban is lifted, got", err)
+	}
+}
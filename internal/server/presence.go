@@ -0,0 +1,186 @@
+package server
+
+import (
+	"cicada"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// presence is the tracked state of a user who has connected since the
+// server started. Users without an entry are offline.
+type presence struct {
+	state string
+	since time.Time
+	// generation is bumped on every connect and disconnect, so a grace
+	// timer can tell that the user came back before it fired.
+	generation int
+	timer      *time.Timer
+}
+
+// markOnline records that a user has connected. A user reconnecting within
+// the grace period keeps their previous state and no change is announced.
+func (s *ChatService) markOnline(userId string) {
+	s.m.Lock()
+	p, ok := s.presence[userId]
+	if !ok {
+		p = &presence{state: cicada.PresenceOffline}
+		s.presence[userId] = p
+	}
+	p.generation++
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+
+	changed := p.state == cicada.PresenceOffline
+	if changed {
+		p.state = cicada.PresenceOnline
+		p.since = time.Now()
+	}
+	update := presenceOf(userId, p)
+	s.m.Unlock()
+
+	if changed {
+		s.announcePresence(update)
+	}
+}
+
+// markDisconnected starts the grace period after a user's connection drops.
+// The user is announced as offline only if they have not reconnected by the
+// time it ends.
+func (s *ChatService) markDisconnected(userId string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	p, ok := s.presence[userId]
+	if !ok || p.state == cicada.PresenceOffline {
+		return
+	}
+
+	p.generation++
+	generation := p.generation
+	p.timer = time.AfterFunc(s.opts.PresenceGrace, func() {
+		s.expirePresence(userId, generation)
+	})
+}
+
+// expirePresence ends the grace period started by markDisconnected.
+func (s *ChatService) expirePresence(userId string, generation int) {
+	s.m.Lock()
+	p, ok := s.presence[userId]
+	_, connected := s.clients[userId]
+	if !ok || connected || p.generation != generation {
+		s.m.Unlock()
+		return
+	}
+	s.m.Unlock()
+
+	s.markOffline(userId)
+}
+
+// markOffline announces that a user has gone offline without waiting for
+// the grace period, such as when they disconnect on purpose.
+func (s *ChatService) markOffline(userId string) {
+	s.m.Lock()
+	p, ok := s.presence[userId]
+	if !ok || p.state == cicada.PresenceOffline {
+		s.m.Unlock()
+		return
+	}
+
+	p.generation++
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.state = cicada.PresenceOffline
+	p.since = time.Now()
+	update := presenceOf(userId, p)
+	s.m.Unlock()
+
+	s.announcePresence(update)
+}
+
+// SetPresence lets a connected user mark themselves away or back online.
+func (s *ChatService) SetPresence(userId, state string) (cicada.Presence, error) {
+	if state != cicada.PresenceOnline && state != cicada.PresenceAway {
+		return cicada.Presence{}, fmt.Errorf("%w: presence must be %s or %s", cicada.ErrorBadRequest, cicada.PresenceOnline, cicada.PresenceAway)
+	}
+
+	s.m.Lock()
+	p, ok := s.presence[userId]
+	_, connected := s.clients[userId]
+	if !ok || !connected {
+		s.m.Unlock()
+		return cicada.Presence{}, fmt.Errorf("%w: %s is not connected", cicada.ErrorConflict, userId)
+	}
+
+	changed := p.state != state
+	if changed {
+		p.state = state
+		p.since = time.Now()
+	}
+	update := presenceOf(userId, p)
+	s.m.Unlock()
+
+	if changed {
+		s.announcePresence(update)
+	}
+	return update, nil
+}
+
+// RoomPresence lists the presence of every member of a room.
+func (s *ChatService) RoomPresence(userId, roomId string) ([]cicada.Presence, error) {
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(r.Members, userId) {
+		return nil, fmt.Errorf("%w: %s is not a member of room %s", cicada.ErrorForbidden, userId, roomId)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	result := make([]cicada.Presence, len(r.Members))
+	for i, uid := range r.Members {
+		if p, ok := s.presence[uid]; ok {
+			result[i] = presenceOf(uid, p)
+		} else {
+			result[i] = cicada.Presence{UserId: uid, State: cicada.PresenceOffline}
+		}
+	}
+	return result, nil
+}
+
+// announcePresence tells everyone who shares a room with the user about a
+// change in their presence.
+func (s *ChatService) announcePresence(p cicada.Presence) {
+	u, err := s.us.Get(p.UserId)
+	if err != nil {
+		slog.Error("unable to announce presence", "user", p.UserId, "error", err)
+		return
+	}
+
+	peers := []string{}
+	for _, roomId := range u.Rooms {
+		r, err := s.rs.Get(roomId)
+		if err != nil {
+			continue
+		}
+		peers = append(peers, r.Members...)
+	}
+
+	f, err := newFrame(cicada.FramePresence, "", p)
+	if err != nil {
+		slog.Error("unable to announce presence", "user", p.UserId, "error", err)
+		return
+	}
+	s.broadcast(uniqueMembers(peers), p.UserId, f)
+}
+
+func presenceOf(userId string, p *presence) cicada.Presence {
+	return cicada.Presence{UserId: userId, State: p.state, Since: p.since}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"cicada"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// presenceIn takes the presence updates queued for a connection, leaving out
+// any other frame.
+func presenceIn(sub subscription) []string {
+	var states []string
+	for {
+		select {
+		case b := <-sub.message:
+			f := cicada.Frame{}
+			p := cicada.Presence{}
+			if json.Unmarshal(b, &f) == nil && f.Type == cicada.FramePresence && json.Unmarshal(f.Payload, &p) == nil {
+				states = append(states, p.State)
+			}
+		default:
+			return states
+		}
+	}
+}
+
+func TestPresence(t *testing.T) {
+	opts := DefaultOptions()
+	opts.PresenceGrace = 50 * time.Millisecond
+	s, done := service(opts)
+	defer done()
+
+	alice, bob := newUser(s, "alice"), newUser(s, "bob")
+	newRoom(t, s, alice, bob)
+	watcher := subscribe(s, bob)
+
+	var sub subscription
+	connect := func() error {
+		sub = subscribe(s, alice)
+		s.markOnline(alice)
+		return nil
+	}
+	disconnect := func() error {
+		s.unsubscribe(alice, sub)
+		return nil
+	}
+	set := func(state string) func() error {
+		return func() error {
+			_, err := s.SetPresence(alice, state)
+			return err
+		}
+	}
+	wait := func() error {
+		time.Sleep(4 * opts.PresenceGrace)
+		return nil
+	}
+
+	steps := []struct {
+		name      string
+		do        func() error
+		err       error
+		announced []string
+	}{
+		{"connect", connect, nil, []string{cicada.PresenceOnline}},
+		{"away", set(cicada.PresenceAway), nil, []string{cicada.PresenceAway}},
+		{"away again", set(cicada.PresenceAway), nil, nil},
+		{"unknown state", set("busy"), cicada.ErrorBadRequest, nil},
+		{"disconnect", disconnect, nil, nil},
+		{"set while disconnected", set(cicada.PresenceOnline), cicada.ErrorConflict, nil},
+		{"reconnect within grace", connect, nil, nil},
+		{"grace ignored after reconnect", wait, nil, nil},
+		{"back", set(cicada.PresenceOnline), nil, []string{cicada.PresenceOnline}},
+		{"disconnect again", disconnect, nil, nil},
+		{"grace ends", wait, nil, []string{cicada.PresenceOffline}},
+		{"connect after offline", connect, nil, []string{cicada.PresenceOnline}},
+	}
+
+	for _, step := range steps {
+		if err := step.do(); !errors.Is(err, step.err) {
+			t.Fatalf("%s: expected %v, got %v", step.name, step.err, err)
+		}
+		if got := presenceIn(watcher); !slices.Equal(got, step.announced) {
+			t.Errorf("%s: expected %v to be announced, got %v", step.name, step.announced, got)
+		}
+	}
+}
+
+func TestRoomPresence(t *testing.T) {
+	s, done := service(DefaultOptions())
+	defer done()
+
+	alice, bob, outsider := newUser(s, "alice"), newUser(s, "bob"), newUser(s, "outsider")
+	r := newRoom(t, s, alice, bob)
+	subscribe(s, alice)
+	s.markOnline(alice)
+
+	if _, err := s.RoomPresence(outsider, r.Id); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected forbidden for a non member, got", err)
+	}
+
+	list, err := s.RoomPresence(bob, r.Id)
+	if err != nil {
+		t.Fatal("unable to list presence", err)
+	}
+	states := map[string]string{}
+	for _, p := range list {
+		states[p.UserId] = p.State
+	}
+	if states[alice] != cicada.PresenceOnline || states[bob] != cicada.PresenceOffline {
+		t.Error("expected alice online and bob offline, got", states)
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"cicada"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"nhooyr.io/websocket"
+)
+
+// readLoop reads request frames from the client until the connection closes,
+// answering each one with an ack or an error frame.
+func (s *ChatService) readLoop(ctx context.Context, userId string, sub subscription, ws *websocket.Conn) {
+	defer s.unsubscribe(userId, sub)
+	for {
+		mtype, b, err := ws.Read(ctx)
+		if err != nil {
+			if websocket.CloseStatus(err) == -1 {
+				slog.Info("connection closed", "user", userId, "error", err)
+			}
+			return
+		}
+
+		var reply []byte
+		if mtype != websocket.MessageText {
+			reply, err = errorFrame("", fmt.Errorf("%w: expected a text frame", cicada.ErrorBadRequest))
+		} else {
+			reply, err = s.handleFrame(ctx, userId, b)
+		}
+
+		if err != nil {
+			slog.Error("unable to encode reply", "user", userId, "error", err)
+			continue
+		}
+		s.reply(sub, reply)
+	}
+}
+
+// handleFrame dispatches a single request frame and returns the encoded reply.
+func (s *ChatService) handleFrame(ctx context.Context, userId string, b []byte) ([]byte, error) {
+	f := cicada.Frame{}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return errorFrame("", fmt.Errorf("%w: malformed frame", cicada.ErrorBadRequest))
+	}
+
+	var result interface{}
+	var err error
+
+	switch f.Type {
+	case cicada.FramePing:
+		return newFrame(cicada.FramePong, f.Id, nil)
+	case cicada.FrameSendMessage:
+		m := cicada.ChatMessage{}
+		if err = decodePayload(f, &m); err == nil {
+			m.Sender = userId
+			result, err = s.SendMessage(m)
+		}
+	case cicada.FrameEditMessage:
+		p := cicada.EditPayload{}
+		if err = decodePayload(f, &p); err == nil {
+			result, err = s.EditMessage(ctx, userId, p.Id, p.Text)
+		}
+	case cicada.FrameDeleteMessage:
+		p := cicada.MessagePayload{}
+		if err = decodePayload(f, &p); err == nil {
+			result, err = s.DeleteMessage(ctx, userId, p.Id)
+		}
+	case cicada.FrameAddReaction, cicada.FrameRemoveReaction:
+		p := cicada.ReactionPayload{}
+		if err = decodePayload(f, &p); err == nil {
+			result, err = s.React(userId, p.MessageId, p.Emoji, f.Type == cicada.FrameAddReaction)
+		}
+	case cicada.FrameJoinRoom:
+		p := cicada.RoomPayload{}
+		if err = decodePayload(f, &p); err == nil {
+			result, err = s.JoinRoom(ctx, userId, p.RoomId, p.Invite)
+		}
+	case cicada.FrameLeaveRoom:
+		p := cicada.RoomPayload{}
+		if err = decodePayload(f, &p); err == nil {
+			err = s.LeaveRoom(ctx, userId, p.RoomId)
+		}
+	case cicada.FrameOpenDirect:
+		p := cicada.DirectPayload{}
+		if err = decodePayload(f, &p); err == nil {
+			result, err = s.OpenDirect(ctx, userId, p.UserId)
+		}
+	case cicada.FrameTyping:
+		p := cicada.TypingPayload{}
+		if err = decodePayload(f, &p); err == nil {
+			err = s.Typing(userId, p.RoomId, p.Typing)
+		}
+	case cicada.FrameRead:
+		p := cicada.ReadPayload{}
+		if err = decodePayload(f, &p); err == nil {
+			result, err = s.MarkRead(userId, p.RoomId, p.MessageId)
+		}
+	case cicada.FrameSetPresence:
+		p := cicada.PresencePayload{}
+		if err = decodePayload(f, &p); err == nil {
+			result, err = s.SetPresence(userId, p.State)
+		}
+	default:
+		err = fmt.Errorf("%w: unknown frame type %q", cicada.ErrorBadRequest, f.Type)
+	}
+
+	if err != nil {
+		return errorFrame(f.Id, err)
+	}
+	return newFrame(cicada.FrameAck, f.Id, result)
+}
+
+func decodePayload[E any](f cicada.Frame, value *E) error {
+	if len(f.Payload) == 0 {
+		return fmt.Errorf("%w: %s frame requires a payload", cicada.ErrorBadRequest, f.Type)
+	}
+	if err := json.Unmarshal(f.Payload, value); err != nil {
+		return fmt.Errorf("%w: invalid %s payload", cicada.ErrorBadRequest, f.Type)
+	}
+	return nil
+}
+
+// newFrame encodes a frame of the given type with an optional payload.
+func newFrame(frameType, id string, payload interface{}) ([]byte, error) {
+	f := cicada.Frame{Type: frameType, Id: id}
+	if payload != nil {
+		p, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		f.Payload = p
+	}
+	return json.Marshal(f)
+}
+
+func errorFrame(id string, e error) ([]byte, error) {
+	code := cicada.CodeInternal
+	mesg := "internal error"
+	if errors.Is(e, cicada.ErrorNotFound) {
+		code = cicada.CodeNotFound
+	} else if errors.Is(e, cicada.ErrorBadRequest) {
+		code = cicada.CodeBadRequest
+	} else if errors.Is(e, cicada.ErrorForbidden) {
+		code = cicada.CodeForbidden
+	} else if errors.Is(e, cicada.ErrorConflict) {
+		code = cicada.CodeConflict
+	}
+
+	if code != cicada.CodeInternal {
+		mesg = e.Error()
+	} else {
+		slog.Error("request failed", "frame", id, "error", e)
+	}
+
+	return newFrame(cicada.FrameError, id, cicada.ErrorPayload{Code: code, Message: mesg})
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"cicada"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestHandleFrame(t *testing.T) {
+	s, done := service(DefaultOptions())
+	defer done()
+
+	owner := newUser(s, "owner")
+	r := newRoom(t, s, owner)
+
+	tests := []struct {
+		name  string
+		frame string
+		typ   string
+		id    string
+		code  string
+	}{
+		{"ping", `{"type":"ping","id":"1"}`, cicada.FramePong, "1", ""},
+		{"send", `{"type":"message.send","id":"1","payload":{"roomId":"` + r.Id + `","text":"hi"}}`, cicada.FrameAck, "1", ""},
+		{"malformed", `{"type":`, cicada.FrameError, "", cicada.CodeBadRequest},
+		{"unknown type", `{"type":"message.shout","id":"1"}`, cicada.FrameError, "1", cicada.CodeBadRequest},
+		{"no payload", `{"type":"message.send","id":"1"}`, cicada.FrameError, "1", cicada.CodeBadRequest},
+		{"bad payload", `{"type":"message.send","id":"1","payload":[1]}`, cicada.FrameError, "1", cicada.CodeBadRequest},
+		{"missing room", `{"type":"message.send","id":"1","payload":{"roomId":"nowhere","text":"hi"}}`, cicada.FrameError, "1", cicada.CodeNotFound},
+		{"missing message", `{"type":"message.delete","id":"1","payload":{"id":"nothing"}}`, cicada.FrameError, "1", cicada.CodeNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := s.handleFrame(context.Background(), owner, []byte(tt.frame))
+			if err != nil {
+				t.Fatal("unable to handle frame", err)
+			}
+
+			f := cicada.Frame{}
+			if err = json.Unmarshal(b, &f); err != nil {
+				t.Fatal("unable to decode reply", err)
+			}
+			if f.Type != tt.typ {
+				t.Errorf("expected a %s frame, got %s", tt.typ, b)
+			}
+			if f.Id != tt.id {
+				t.Errorf("expected id %q to be echoed, got %q", tt.id, f.Id)
+			}
+
+			if len(tt.code) != 0 {
+				p := cicada.ErrorPayload{}
+				if err = json.Unmarshal(f.Payload, &p); err != nil || p.Code != tt.code {
+					t.Errorf("expected error code %s, got %s", tt.code, f.Payload)
+				}
+			}
+		})
+	}
+}
+
+func TestSendFrameSender(t *testing.T) {
+	s, done := service(DefaultOptions())
+	defer done()
+
+	owner, member := newUser(s, "owner"), newUser(s, "member")
+	r := newRoom(t, s, owner, member)
+
+	// the sender is the connected user, whatever the payload claims
+	frame := `{"type":"message.send","id":"1","payload":{"roomId":"` + r.Id + `","sender":"` + owner + `","text":"hi"}}`
+	b, err := s.handleFrame(context.Background(), member, []byte(frame))
+	if err != nil {
+		t.Fatal("unable to handle frame", err)
+	}
+
+	f := cicada.Frame{}
+	m := cicada.ChatMessage{}
+	if err = json.Unmarshal(b, &f); err == nil {
+		err = json.Unmarshal(f.Payload, &m)
+	}
+	if err != nil || f.Type != cicada.FrameAck {
+		t.Fatal("expected an ack", string(b), err)
+	}
+	if m.Sender != member {
+		t.Error("expected the message to be sent as the connected user, got", m.Sender)
+	}
+}
+
+func TestErrorFrame(t *testing.T) {
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{fmt.Errorf("%w: no such room", cicada.ErrorNotFound), cicada.CodeNotFound},
+		{fmt.Errorf("%w: bad", cicada.ErrorBadRequest), cicada.CodeBadRequest},
+		{fmt.Errorf("%w: no", cicada.ErrorForbidden), cicada.CodeForbidden},
+		{fmt.Errorf("%w: taken", cicada.ErrorConflict), cicada.CodeConflict},
+		{errors.New("disk on fire"), cicada.CodeInternal},
+	}
+
+	for _, tt := range tests {
+		b, err := errorFrame("7", tt.err)
+		if err != nil {
+			t.Fatal("unable to encode error frame", err)
+		}
+
+		f := cicada.Frame{}
+		p := cicada.ErrorPayload{}
+		if err = json.Unmarshal(b, &f); err == nil {
+			err = json.Unmarshal(f.Payload, &p)
+		}
+		if err != nil || f.Type != cicada.FrameError || f.Id != "7" || p.Code != tt.code {
+			t.Errorf("expected a %s error frame for %v, got %s", tt.code, tt.err, b)
+		}
+	}
+
+	// internal failures are not described to the client
+	b, _ := errorFrame("7", errors.New("disk on fire"))
+	f := cicada.Frame{}
+	p := cicada.ErrorPayload{}
+	if json.Unmarshal(b, &f) != nil || json.Unmarshal(f.Payload, &p) != nil || p.Message != "internal error" {
+		t.Error("expected internal errors to be hidden, got", string(b))
+	}
+}
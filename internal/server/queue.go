@@ -0,0 +1,147 @@
+package server
+
+import (
+	"cicada"
+	"cicada/internal/server/store/chat"
+	"log/slog"
+	"sync"
+)
+
+// Overflow selects what happens when a client's outbound queue is full.
+type Overflow string
+
+const (
+	// DropOldest discards the oldest queued frame to make room for the new
+	// one. Before the next frame is written, the client is sent an overflow
+	// frame with the cursor to reconnect from to have the dropped messages
+	// replayed. Replies to the client's requests are never dropped.
+	DropOldest Overflow = "drop_oldest"
+	// Disconnect closes the connection of a client that cannot keep up.
+	Disconnect Overflow = "disconnect"
+)
+
+// QueueMetrics describes the outbound queues of the connected clients.
+type QueueMetrics struct {
+	// Connections is the number of connected clients.
+	Connections int `json:"connections"`
+	// Capacity is the most frames each client's queue holds.
+	Capacity int `json:"capacity"`
+	// Queued is the number of frames waiting across all queues, not
+	// counting replies to requests.
+	Queued int `json:"queued"`
+	// MaxDepth is the number of frames waiting in the fullest queue.
+	MaxDepth int `json:"maxDepth"`
+	// Dropped counts the frames discarded since the service started.
+	Dropped int64 `json:"dropped"`
+	// Disconnected counts the clients dropped for falling behind since the
+	// service started.
+	Disconnected int64 `json:"disconnected"`
+}
+
+// QueueMetrics reports the current depth of the outbound queues and how
+// often they have overflowed.
+func (s *ChatService) QueueMetrics() QueueMetrics {
+	s.m.Lock()
+	m := QueueMetrics{Connections: len(s.clients), Capacity: s.opts.QueueSize}
+	for _, sub := range s.clients {
+		depth := len(sub.message)
+		m.Queued += depth
+		m.MaxDepth = max(m.MaxDepth, depth)
+	}
+	s.m.Unlock()
+
+	m.Dropped = s.dropped.Load()
+	m.Disconnected = s.disconnected.Load()
+	return m
+}
+
+// send queues a frame pushed to a connection without waiting for its write
+// loop, so a slow client never holds up the sender. When the queue is full
+// the overflow policy decides whether the oldest frame is dropped or the
+// client is disconnected.
+func (s *ChatService) send(sub subscription, f []byte) {
+	for {
+		select {
+		case sub.message <- f:
+			return
+		case <-sub.quit:
+			return
+		default:
+		}
+
+		if s.opts.Overflow == Disconnect {
+			s.disconnectSlow(sub)
+			return
+		}
+
+		select {
+		case old := <-sub.message:
+			s.drop(sub, old)
+		default:
+		}
+	}
+}
+
+// reply queues the answer to one of the client's requests. Replies are never
+// dropped, so a client that stops reading them is no longer read from
+// either.
+func (s *ChatService) reply(sub subscription, f []byte) {
+	select {
+	case sub.replies <- f:
+	case <-sub.quit:
+	}
+}
+
+// drop discards a frame from a full queue, keeping track of the gap it leaves.
+func (s *ChatService) drop(sub subscription, f []byte) {
+	s.dropped.Add(1)
+	sub.gap.add(f)
+}
+
+// disconnectSlow closes the connection of a client whose queue overflowed.
+// It reconnects as it would after any other dropped connection.
+func (s *ChatService) disconnectSlow(sub subscription) {
+	if s.unsubscribe(sub.userId, sub) {
+		s.disconnected.Add(1)
+		slog.Warn("disconnected slow client", "user", sub.userId, "queued", s.opts.QueueSize)
+	}
+}
+
+// gap records the frames dropped from a queue since the client was last told.
+type gap struct {
+	mu      sync.Mutex
+	dropped int
+	first   chat.Cursor
+}
+
+func (g *gap) add(f []byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.dropped++
+	if m, ok := messageIn(f); ok && g.first.IsZero() {
+		g.first = chat.CursorBefore(m)
+	}
+}
+
+// take returns an overflow frame describing the gap and clears it, or nil if
+// nothing was dropped.
+func (g *gap) take() []byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.dropped == 0 {
+		return nil
+	}
+
+	p := cicada.OverflowPayload{Dropped: g.dropped}
+	if !g.first.IsZero() {
+		p.Cursor = g.first.String()
+	}
+	g.dropped, g.first = 0, chat.Cursor{}
+
+	f, err := newFrame(cicada.FrameOverflow, "", p)
+	if err != nil {
+		slog.Error("unable to encode overflow frame", "error", err)
+		return nil
+	}
+	return f
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"cicada"
+	"cicada/internal/server/store/chat"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// subscribe registers a connection for a user without a websocket, so its
+// queue fills up as nothing writes it out.
+func subscribe(s *ChatService, userId string) subscription {
+	sub := subscription{
+		userId:  userId,
+		message: make(chan []byte, s.opts.QueueSize),
+		replies: make(chan []byte, s.opts.QueueSize),
+		gap:     &gap{},
+		quit:    make(chan interface{}),
+	}
+	s.m.Lock()
+	s.clients[userId] = sub
+	s.m.Unlock()
+	return sub
+}
+
+func messages(count int) ([]cicada.ChatMessage, [][]byte) {
+	now := time.Now()
+	messages := make([]cicada.ChatMessage, count)
+	frames := make([][]byte, count)
+	for i := range messages {
+		messages[i] = cicada.ChatMessage{Id: "m" + strconv.Itoa(i), RoomId: "room", Date: now.Add(time.Duration(i) * time.Second), Text: "hello"}
+		frames[i], _ = messageFrame(messages[i])
+	}
+	return messages, frames
+}
+
+func TestDropOldest(t *testing.T) {
+	opts := DefaultOptions()
+	opts.QueueSize = 2
+	s, done := service(opts)
+	defer done()
+
+	sub := subscribe(s, "user1")
+	sent, frames := messages(4)
+	for _, f := range frames {
+		s.send(sub, f)
+	}
+	s.reply(sub, []byte("ack"))
+
+	if len(sub.message) != 2 {
+		t.Fatal("expected a full queue, got", len(sub.message))
+	}
+	if m, _ := messageIn(<-sub.message); m.Id != sent[2].Id {
+		t.Error("expected the oldest frames to be dropped, got", m.Id)
+	}
+	if len(sub.replies) != 1 {
+		t.Error("expected the reply to be kept")
+	}
+
+	f := cicada.Frame{}
+	if err := json.Unmarshal(sub.gap.take(), &f); err != nil || f.Type != cicada.FrameOverflow {
+		t.Fatal("expected an overflow frame, got", f, err)
+	}
+	p := cicada.OverflowPayload{}
+	if err := json.Unmarshal(f.Payload, &p); err != nil || p.Dropped != 2 {
+		t.Fatal("expected two dropped frames, got", p, err)
+	}
+	c, err := chat.ParseCursor(p.Cursor)
+	if err != nil {
+		t.Fatal("expected a cursor, got", p.Cursor)
+	}
+	if !c.Date.Before(sent[0].Date) || !c.Date.After(sent[0].Date.Add(-time.Second)) {
+		t.Error("expected the cursor to be just before the first dropped message, got", c)
+	}
+	if sub.gap.take() != nil {
+		t.Error("expected the gap to be cleared once reported")
+	}
+
+	m := s.QueueMetrics()
+	if m.Dropped != 2 || m.Connections != 1 || m.Queued != 1 || m.MaxDepth != 1 || m.Capacity != 2 {
+		t.Error("unexpected metrics", m)
+	}
+}
+
+func TestDisconnectSlow(t *testing.T) {
+	opts := DefaultOptions()
+	opts.QueueSize = 2
+	opts.Overflow = Disconnect
+	s, done := service(opts)
+	defer done()
+
+	slow := subscribe(s, "user1")
+	subscribe(s, "user2")
+	_, frames := messages(3)
+	for _, f := range frames {
+		s.broadcast([]string{"user1", "user2"}, "", f)
+	}
+
+	select {
+	case <-slow.quit:
+	default:
+		t.Fatal("expected the slow client to be disconnected")
+	}
+
+	m := s.QueueMetrics()
+	if m.Disconnected != 2 || m.Connections != 0 || m.Dropped != 0 {
+		t.Error("unexpected metrics", m)
+	}
+
+	// a client that keeps up stays connected
+	fast := subscribe(s, "user3")
+	s.send(fast, frames[0])
+	<-fast.message
+	s.send(fast, frames[1])
+	s.send(fast, frames[2])
+	if m := s.QueueMetrics(); m.Connections != 1 || m.MaxDepth != 2 || m.Disconnected != 2 {
+		t.Error("unexpected metrics", m)
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"cicada"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// React adds or removes a user's reaction to a message in one of their rooms.
+// Each user has at most one of each emoji on a message. When the reactions
+// change, the room is sent an update.
+func (s *ChatService) React(userId, messageId, emoji string, add bool) (cicada.ReactionUpdate, error) {
+	if err := validateEmoji(emoji); err != nil {
+		return cicada.ReactionUpdate{}, err
+	}
+
+	s.m.Lock()
+	m, err := s.cs.Get(messageId)
+	if err != nil {
+		s.m.Unlock()
+		return cicada.ReactionUpdate{}, err
+	}
+
+	r, err := s.rs.Get(m.RoomId)
+	if err != nil {
+		s.m.Unlock()
+		return cicada.ReactionUpdate{}, err
+	}
+
+	if err = s.authorize(r, userId, PermPost); err != nil {
+		s.m.Unlock()
+		return cicada.ReactionUpdate{}, err
+	}
+	if m.Deleted {
+		s.m.Unlock()
+		return cicada.ReactionUpdate{}, fmt.Errorf("%w: message %s has been deleted", cicada.ErrorConflict, messageId)
+	}
+
+	m, changed, err := s.cs.React(messageId, emoji, userId, add)
+	s.m.Unlock()
+	if err != nil {
+		return cicada.ReactionUpdate{}, err
+	}
+
+	update := cicada.ReactionUpdate{
+		MessageId: m.Id,
+		RoomId:    m.RoomId,
+		UserId:    userId,
+		Emoji:     emoji,
+		Added:     add,
+		Users:     m.Reactions[emoji],
+	}
+	if update.Users == nil {
+		update.Users = []string{}
+	}
+
+	if changed {
+		f, err := newFrame(cicada.FrameReaction, "", update)
+		if err != nil {
+			return cicada.ReactionUpdate{}, err
+		}
+		s.broadcast(r.Members, "", f)
+	}
+	return update, nil
+}
+
+func validateEmoji(emoji string) error {
+	if len(emoji) == 0 {
+		return fmt.Errorf("%w: reaction is empty", cicada.ErrorBadRequest)
+	}
+	if utf8.RuneCountInString(emoji) > MaxEmojiLength {
+		return fmt.Errorf("%w: reaction is longer than %d characters", cicada.ErrorBadRequest, MaxEmojiLength)
+	}
+	if !utf8.ValidString(emoji) || !isEmoji([]rune(emoji)) {
+		return fmt.Errorf("%w: reaction %q is not a single emoji", cicada.ErrorBadRequest, emoji)
+	}
+	return nil
+}
+
+const (
+	zeroWidthJoiner  = 0x200D
+	variation16      = 0xFE0F
+	combiningKeycap  = 0x20E3
+	regionalA        = 0x1F1E6
+	regionalZ        = 0x1F1FF
+	skinToneLight    = 0x1F3FB
+	skinToneDark     = 0x1F3FF
+	tagFirst         = 0xE0020
+	tagLast          = 0xE007F
+	keycapCharacters = "0123456789#*"
+)
+
+// pictographic holds the code points emoji are drawn from, leaving out the
+// regional indicators and skin tones, which only modify or pair with others.
+var pictographic = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00A9, Hi: 0x00A9, Stride: 1},
+		{Lo: 0x00AE, Hi: 0x00AE, Stride: 1},
+		{Lo: 0x203C, Hi: 0x203C, Stride: 1},
+		{Lo: 0x2049, Hi: 0x2049, Stride: 1},
+		{Lo: 0x2122, Hi: 0x2122, Stride: 1},
+		{Lo: 0x2139, Hi: 0x2139, Stride: 1},
+		{Lo: 0x2194, Hi: 0x2199, Stride: 1},
+		{Lo: 0x21A9, Hi: 0x21AA, Stride: 1},
+		{Lo: 0x231A, Hi: 0x231B, Stride: 1},
+		{Lo: 0x2328, Hi: 0x2328, Stride: 1},
+		{Lo: 0x23CF, Hi: 0x23CF, Stride: 1},
+		{Lo: 0x23E9, Hi: 0x23F3, Stride: 1},
+		{Lo: 0x23F8, Hi: 0x23FA, Stride: 1},
+		{Lo: 0x24C2, Hi: 0x24C2, Stride: 1},
+		{Lo: 0x25AA, Hi: 0x25AB, Stride: 1},
+		{Lo: 0x25B6, Hi: 0x25B6, Stride: 1},
+		{Lo: 0x25C0, Hi: 0x25C0, Stride: 1},
+		{Lo: 0x25FB, Hi: 0x25FE, Stride: 1},
+		{Lo: 0x2600, Hi: 0x27BF, Stride: 1},
+		{Lo: 0x2934, Hi: 0x2935, Stride: 1},
+		{Lo: 0x2B05, Hi: 0x2B07, Stride: 1},
+		{Lo: 0x2B1B, Hi: 0x2B1C, Stride: 1},
+		{Lo: 0x2B50, Hi: 0x2B50, Stride: 1},
+		{Lo: 0x2B55, Hi: 0x2B55, Stride: 1},
+		{Lo: 0x3030, Hi: 0x3030, Stride: 1},
+		{Lo: 0x303D, Hi: 0x303D, Stride: 1},
+		{Lo: 0x3297, Hi: 0x3297, Stride: 1},
+		{Lo: 0x3299, Hi: 0x3299, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1F000, Hi: 0x1F1E5, Stride: 1},
+		{Lo: 0x1F200, Hi: 0x1F3FA, Stride: 1},
+		{Lo: 0x1F400, Hi: 0x1FAFF, Stride: 1},
+	},
+	LatinOffset: 2,
+}
+
+// isEmoji reports whether a string is exactly one emoji: a keycap, a flag
+// made of two regional indicators, or pictographs joined with zero width
+// joiners, each optionally followed by a variation selector, a skin tone and
+// tags.
+func isEmoji(rs []rune) bool {
+	if strings.ContainsRune(keycapCharacters, rs[0]) {
+		return len(rs) == 2 && rs[1] == combiningKeycap ||
+			len(rs) == 3 && rs[1] == variation16 && rs[2] == combiningKeycap
+	}
+	if regional(rs[0]) {
+		return len(rs) == 2 && regional(rs[1])
+	}
+
+	for i := 0; ; i++ {
+		if i == len(rs) || !unicode.Is(pictographic, rs[i]) {
+			return false
+		}
+		if i+1 < len(rs) && rs[i+1] == variation16 {
+			i++
+		}
+		if i+1 < len(rs) && rs[i+1] >= skinToneLight && rs[i+1] <= skinToneDark {
+			i++
+		}
+		for i+1 < len(rs) && rs[i+1] >= tagFirst && rs[i+1] <= tagLast {
+			i++
+		}
+		if i+1 == len(rs) {
+			return true
+		}
+		if rs[i+1] != zeroWidthJoiner {
+			return false
+		}
+		i++
+	}
+}
+
+func regional(r rune) bool {
+	return r >= regionalA && r <= regionalZ
+}
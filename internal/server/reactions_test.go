@@ -0,0 +1,83 @@
+package server
+
+import (
+	"cicada"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateEmoji(t *testing.T) {
+	tests := []struct {
+		name  string
+		emoji string
+		valid bool
+	}{
+		{"simple", "👍", true},
+		{"symbol with variation selector", "❤️", true},
+		{"symbol without variation selector", "❤", true},
+		{"skin tone", "👍🏽", true},
+		{"flag", "🇫🇷", true},
+		{"family", "👨‍👩‍👧", true},
+		{"joined with skin tone", "🧑🏽‍💻", true},
+		{"keycap", "1️⃣", true},
+		{"keycap without variation selector", "#⃣", true},
+		{"subdivision flag", "🏴\U000e0067\U000e0062\U000e0073\U000e0063\U000e0074\U000e007f", true},
+		{"empty", "", false},
+		{"text", "lol", false},
+		{"letter", "a", false},
+		{"digit", "1", false},
+		{"text after emoji", "👍ok", false},
+		{"two emoji", "👍👍", false},
+		{"space", " 👍", false},
+		{"control", "👍\n", false},
+		{"lone skin tone", "🏽", false},
+		{"lone regional indicator", "🇫", false},
+		{"three regional indicators", "🇫🇷🇫", false},
+		{"trailing joiner", "👨\u200d", false},
+		{"leading joiner", "\u200d👨", false},
+		{"lone variation selector", "\ufe0f", false},
+		{"cjk", "字", false},
+		{"invalid utf-8", "\xf0\x9f", false},
+		{"too long", strings.Repeat("👨\u200d", MaxEmojiLength/2) + "👨", false},
+	}
+
+	for _, tt := range tests {
+		err := validateEmoji(tt.emoji)
+		if tt.valid && err != nil {
+			t.Errorf("%s: expected %q to be accepted, got %v", tt.name, tt.emoji, err)
+		}
+		if !tt.valid && !errors.Is(err, cicada.ErrorBadRequest) {
+			t.Errorf("%s: expected %q to be rejected, got %v", tt.name, tt.emoji, err)
+		}
+	}
+}
+
+func TestReactRejectsText(t *testing.T) {
+	s, done := service(DefaultOptions())
+	defer done()
+
+	owner := newUser(s, "owner")
+	r := newRoom(t, s, owner)
+	m, err := s.SendMessage(cicada.ChatMessage{RoomId: r.Id, Sender: owner, Text: "hi"})
+	if err != nil {
+		t.Fatal("unable to send message", err)
+	}
+
+	for _, emoji := range []string{"", "+1", "<b>"} {
+		if _, err = s.React(owner, m.Id, emoji, true); !errors.Is(err, cicada.ErrorBadRequest) {
+			t.Errorf("expected bad request reacting with %q, got %v", emoji, err)
+		}
+	}
+	if _, err = s.React(owner, m.Id, "👍", true); err != nil {
+		t.Error("expected an emoji reaction to be accepted, got", err)
+	}
+
+	stored, err := s.cs.Get(m.Id)
+	if err != nil {
+		t.Fatal("unable to read message", err)
+	}
+	if len(stored.Reactions) != 1 || len(stored.Reactions["👍"]) != 1 {
+		t.Error("expected only the emoji reaction to be stored, got", stored.Reactions)
+	}
+}
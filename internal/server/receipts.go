@@ -0,0 +1,92 @@
+package server
+
+import (
+	"cicada"
+	"cicada/internal/server/store/chat"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// MarkRead records that a user has read a room up to a message, or up to its
+// newest message when no message id is given. Read markers only move forward.
+// When the marker moves, the other members of the room are sent a receipt.
+func (s *ChatService) MarkRead(userId, roomId, messageId string) (cicada.ReadReceipt, error) {
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return cicada.ReadReceipt{}, err
+	}
+
+	if !slices.Contains(r.Members, userId) {
+		return cicada.ReadReceipt{}, fmt.Errorf("%w: %s is not a member of room %s", cicada.ErrorForbidden, userId, roomId)
+	}
+
+	var m cicada.ChatMessage
+	if len(messageId) == 0 {
+		latest, err := s.cs.Page(roomId, chat.Cursor{}, chat.Before, 1)
+		if err != nil {
+			return cicada.ReadReceipt{}, err
+		}
+		if len(latest) == 0 {
+			return cicada.ReadReceipt{}, fmt.Errorf("%w: room %s has no messages", cicada.ErrorBadRequest, roomId)
+		}
+		m = latest[0]
+	} else {
+		m, err = s.cs.Get(messageId)
+		if errors.Is(err, cicada.ErrorNotFound) || (err == nil && m.RoomId != roomId) {
+			return cicada.ReadReceipt{}, fmt.Errorf("%w: no message %s in room %s", cicada.ErrorBadRequest, messageId, roomId)
+		} else if err != nil {
+			return cicada.ReadReceipt{}, err
+		}
+	}
+
+	receipt, moved, err := s.cs.MarkRead(cicada.ReadReceipt{
+		UserId:    userId,
+		RoomId:    roomId,
+		MessageId: m.Id,
+		Date:      m.Date,
+	})
+	if err != nil {
+		return cicada.ReadReceipt{}, err
+	}
+
+	if moved {
+		f, err := newFrame(cicada.FrameReceipt, "", receipt)
+		if err != nil {
+			slog.Error("unable to send read receipt", "room", roomId, "user", userId, "error", err)
+		} else {
+			s.broadcast(r.Members, userId, f)
+		}
+	}
+	return receipt, nil
+}
+
+// Rooms lists the rooms a user belongs to, with how many messages from
+// others they have not read in each.
+func (s *ChatService) Rooms(userId string) ([]cicada.RoomSummary, error) {
+	rooms, err := s.rs.GetForUser(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]cicada.RoomSummary, len(rooms))
+	for i, r := range rooms {
+		summaries[i].Room = r
+
+		c := chat.Cursor{}
+		receipt, err := s.cs.LastRead(userId, r.Id)
+		if err == nil {
+			summaries[i].LastRead = &receipt
+			c = chat.CursorOfReceipt(receipt)
+		} else if !errors.Is(err, cicada.ErrorNotFound) {
+			return nil, err
+		}
+
+		summaries[i].Unread, err = s.cs.CountAfter(r.Id, c, userId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return summaries, nil
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"cicada"
+	"cicada/internal/server/store/chat"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"nhooyr.io/websocket"
+)
+
+// replay is what a reconnecting client missed: a frame for each message,
+// ending with a replay.end frame, and the ids of the messages sent.
+type replay struct {
+	frames [][]byte
+	ids    map[string]bool
+}
+
+// ReplayCursor resolves where a reconnecting client left off. The position
+// is either a cursor from a replay.end frame or the id of the last message
+// the client saw. A message that has since been deleted or pruned cannot be
+// resumed from.
+func (s *ChatService) ReplayCursor(position string) (chat.Cursor, error) {
+	c, err := chat.ParseCursor(position)
+	if err == nil {
+		return c, nil
+	}
+
+	m, err := s.cs.Get(position)
+	if errors.Is(err, cicada.ErrorNotFound) {
+		return chat.Cursor{}, fmt.Errorf("%w: %s is not a cursor or message id", cicada.ErrorBadRequest, position)
+	} else if err != nil {
+		return chat.Cursor{}, err
+	}
+	return chat.CursorOf(m), nil
+}
+
+// replay reads the messages sent to a user's rooms after since and hands
+// them to the write loop. An error frame takes the place of replay.end when
+// they cannot be read, so the client knows to fall back to history requests.
+func (s *ChatService) replay(userId string, since chat.Cursor, out chan<- replay) {
+	r, err := s.backlog(userId, since)
+	if err != nil {
+		slog.Error("unable to replay missed messages", "user", userId, "error", err)
+		f, _ := errorFrame("", err)
+		r = replay{frames: [][]byte{f}}
+	}
+	out <- r
+}
+
+// backlog builds the replay for a user, limited to Options.MaxReplay messages.
+func (s *ChatService) backlog(userId string, since chat.Cursor) (replay, error) {
+	rooms, err := s.rs.GetForUser(userId)
+	if err != nil {
+		return replay{}, err
+	}
+	roomIds := make([]string, len(rooms))
+	for i, r := range rooms {
+		roomIds[i] = r.Id
+	}
+
+	messages, err := s.cs.Since(roomIds, since, s.opts.MaxReplay+1)
+	if err != nil {
+		return replay{}, err
+	}
+
+	end := cicada.ReplayPayload{More: len(messages) > s.opts.MaxReplay, Cursor: since.String()}
+	if end.More {
+		messages = messages[:s.opts.MaxReplay]
+	}
+
+	r := replay{frames: make([][]byte, 0, len(messages)+1), ids: make(map[string]bool, len(messages))}
+	for _, m := range messages {
+		f, err := messageFrame(m)
+		if err != nil {
+			return replay{}, err
+		}
+		r.frames = append(r.frames, f)
+		r.ids[m.Id] = true
+	}
+
+	end.Count = len(messages)
+	if len(messages) > 0 {
+		end.Cursor = chat.CursorOf(messages[len(messages)-1]).String()
+	}
+	f, err := newFrame(cicada.FrameReplayEnd, "", end)
+	if err != nil {
+		return replay{}, err
+	}
+	r.frames = append(r.frames, f)
+	return r, nil
+}
+
+// includes reports whether a live frame carries a message that was already
+// replayed, which happens when it was saved before the replay was read but
+// delivered after the connection was registered.
+func (r replay) includes(f []byte) bool {
+	if len(r.ids) == 0 {
+		return false
+	}
+	m, ok := messageIn(f)
+	return ok && r.ids[m.Id]
+}
+
+// messageIn decodes the message carried by a message or thread message frame.
+func messageIn(f []byte) (cicada.ChatMessage, bool) {
+	frame := cicada.Frame{}
+	if err := json.Unmarshal(f, &frame); err != nil {
+		return cicada.ChatMessage{}, false
+	}
+	if frame.Type != cicada.FrameMessage && frame.Type != cicada.FrameReply {
+		return cicada.ChatMessage{}, false
+	}
+
+	m := cicada.ChatMessage{}
+	if err := json.Unmarshal(frame.Payload, &m); err != nil {
+		return cicada.ChatMessage{}, false
+	}
+	return m, true
+}
+
+// writeReplay writes the replay once it has been read, holding back live
+// frames until it is done so the replayed messages come first. Replies to
+// requests are not held back. The held frames are bounded like the queue
+// they came from. It reports whether the connection is still usable.
+func (s *ChatService) writeReplay(sub subscription, in <-chan replay, ws *websocket.Conn) bool {
+	var held [][]byte
+	for {
+		select {
+		case <-sub.quit:
+			return false
+		case mesg := <-sub.replies:
+			if err := messageWithTimeout(mesg, ws, s.opts.WriteTimeout); err != nil {
+				return false
+			}
+		case mesg := <-sub.message:
+			if len(held) == s.opts.QueueSize {
+				if s.opts.Overflow == Disconnect {
+					s.disconnectSlow(sub)
+					return false
+				}
+				s.drop(sub, held[0])
+				held = held[1:]
+			}
+			held = append(held, mesg)
+		case r := <-in:
+			for _, mesg := range r.frames {
+				if err := messageWithTimeout(mesg, ws, s.opts.WriteTimeout); err != nil {
+					return false
+				}
+			}
+			if f := sub.gap.take(); f != nil {
+				held = append([][]byte{f}, held...)
+			}
+			for _, mesg := range held {
+				if r.includes(mesg) {
+					continue
+				}
+				if err := messageWithTimeout(mesg, ws, s.opts.WriteTimeout); err != nil {
+					return false
+				}
+			}
+			return true
+		}
+	}
+}
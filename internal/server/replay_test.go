@@ -0,0 +1,61 @@
+package server
+
+import (
+	"cicada"
+	"cicada/internal/server/store/chat"
+	"encoding/json"
+	"testing"
+)
+
+func TestBacklog(t *testing.T) {
+	s, done := service(DefaultOptions())
+	defer done()
+
+	owner, member := newUser(s, "owner"), newUser(s, "member")
+	shared, other := newRoom(t, s, owner, member), newRoom(t, s, owner)
+
+	send := func(roomId, text string) cicada.ChatMessage {
+		m, err := s.SendMessage(cicada.ChatMessage{RoomId: roomId, Sender: owner, Text: text})
+		if err != nil {
+			t.Fatal("unable to send message", err)
+		}
+		return m
+	}
+	seen := send(shared.Id, "seen")
+	missed := send(shared.Id, "missed")
+	hidden := send(other.Id, "hidden")
+
+	r, err := s.backlog(member, chat.CursorOf(seen))
+	if err != nil {
+		t.Fatal("unable to read backlog", err)
+	}
+	// join announcements are made in the background, so leave them out
+	var replayed []cicada.ChatMessage
+	for _, f := range r.frames {
+		if m, ok := messageIn(f); ok && m.Sender != systemSender {
+			replayed = append(replayed, m)
+		}
+	}
+	if len(replayed) != 1 || replayed[0].Id != missed.Id {
+		t.Error("expected the missed message to be replayed, got", replayed)
+	}
+
+	f := cicada.Frame{}
+	end := cicada.ReplayPayload{}
+	if err = json.Unmarshal(r.frames[len(r.frames)-1], &f); err == nil {
+		err = json.Unmarshal(f.Payload, &end)
+	}
+	if err != nil || f.Type != cicada.FrameReplayEnd || end.Count != len(r.frames)-1 || end.More {
+		t.Error("unexpected replay.end frame", f.Type, end, err)
+	}
+
+	// a live frame for a replayed message is not sent again
+	live, _ := messageFrame(missed)
+	if !r.includes(live) {
+		t.Error("expected the replayed message to be recognised")
+	}
+	live, _ = messageFrame(hidden)
+	if r.includes(live) {
+		t.Error("expected a message that was not replayed to be sent")
+	}
+}
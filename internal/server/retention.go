@@ -0,0 +1,201 @@
+package server
+
+import (
+	"cicada"
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// RetentionReport says what a pass of PruneMessages removed.
+type RetentionReport struct {
+	// Rooms is the number of rooms messages were removed from.
+	Rooms int `json:"rooms"`
+	// Messages is the number of messages removed, replies included.
+	Messages int `json:"messages"`
+	// Images is the number of image references released.
+	Images int `json:"images"`
+	// Held is the number of rooms skipped because of a legal hold.
+	Held int `json:"held"`
+}
+
+// SetRetention sets a room's own retention limits. They can only make the
+// server's limits stricter, never looser. A zero retention removes them.
+func (s *ChatService) SetRetention(ctx context.Context, userId, roomId string, p cicada.Retention) (cicada.Room, error) {
+	if p.MaxAge < 0 || p.MaxMessages < 0 {
+		return cicada.Room{}, fmt.Errorf("%w: retention limits must not be negative", cicada.ErrorBadRequest)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return cicada.Room{}, err
+	}
+
+	if err = s.authorize(r, userId, PermRetention); err != nil {
+		return cicada.Room{}, err
+	}
+
+	r.Retention = nil
+	if !p.IsZero() {
+		r.Retention = &p
+	}
+	if err = s.rs.Update(r); err != nil {
+		return cicada.Room{}, err
+	}
+
+	s.audit(ctx, cicada.AuditEvent{
+		Actor:   userId,
+		Action:  cicada.AuditRoomRetention,
+		RoomId:  roomId,
+		Changes: map[string]string{"maxAge": p.MaxAge.String(), "maxMessages": strconv.Itoa(p.MaxMessages)},
+	})
+	return r, nil
+}
+
+// SetLegalHold places a room under legal hold, or releases it. Only
+// administrators may do so. Nothing is removed from a held room by
+// retention, and it is kept when its last member leaves.
+func (s *ChatService) SetLegalHold(ctx context.Context, userId, roomId string, hold bool) (cicada.Room, error) {
+	if !s.IsAdmin(userId) {
+		return cicada.Room{}, fmt.Errorf("%w: only administrators may place rooms under legal hold", cicada.ErrorForbidden)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return cicada.Room{}, err
+	}
+
+	if r.LegalHold == hold {
+		return r, nil
+	}
+
+	r.LegalHold = hold
+	if err = s.rs.Update(r); err != nil {
+		return cicada.Room{}, err
+	}
+	s.audit(ctx, cicada.AuditEvent{Actor: userId, Action: cicada.AuditRoomLegalHold, RoomId: roomId, Changes: map[string]string{"legalHold": strconv.FormatBool(hold)}})
+	return r, nil
+}
+
+// PruneMessages removes the messages that have outlived the retention limits
+// of their room, in batches of Options.PruneBatchSize, and releases their
+// images. Rooms under legal hold are skipped, and each room pruned is
+// audited with the number of messages removed.
+func (s *ChatService) PruneMessages() (RetentionReport, error) {
+	// collect the rooms first, so none of them is read while pruning
+	var rooms []cicada.Room
+	err := s.rs.ForEach(func(r cicada.Room) error {
+		rooms = append(rooms, r)
+		return nil
+	})
+	if err != nil {
+		return RetentionReport{}, err
+	}
+
+	report := RetentionReport{}
+	for _, r := range rooms {
+		if r.LegalHold {
+			report.Held++
+			continue
+		}
+
+		p := s.retentionOf(r)
+		if p.IsZero() {
+			continue
+		}
+
+		removed, images, err := s.pruneRoom(r.Id, p)
+		if removed != 0 {
+			report.Rooms++
+			report.Messages += removed
+			report.Images += images
+			s.audit(context.Background(), cicada.AuditEvent{
+				Actor:   systemSender,
+				Action:  cicada.AuditRoomPrune,
+				RoomId:  r.Id,
+				Changes: map[string]string{"messages": strconv.Itoa(removed), "images": strconv.Itoa(images)},
+			})
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// retentionOf combines the server's retention limits with a room's own,
+// keeping the stricter of each.
+func (s *ChatService) retentionOf(r cicada.Room) cicada.Retention {
+	p := s.opts.Retention
+	if r.Retention == nil {
+		return p
+	}
+
+	if own := r.Retention.MaxAge; own > 0 && (p.MaxAge == 0 || own < p.MaxAge) {
+		p.MaxAge = own
+	}
+	if own := r.Retention.MaxMessages; own > 0 && (p.MaxMessages == 0 || own < p.MaxMessages) {
+		p.MaxMessages = own
+	}
+	return p
+}
+
+// pruneRoom removes a room's expired messages one batch at a time, first
+// those too old and then those beyond the newest allowed. The room is read
+// again before each batch, so a legal hold placed during a pass stops it.
+// It returns the number of messages removed and images released.
+func (s *ChatService) pruneRoom(roomId string, p cicada.Retention) (int, int, error) {
+	removed, released := 0, 0
+	for {
+		s.m.Lock()
+		batch, err := s.expired(roomId, p)
+		var ids, images []string
+		if err == nil && len(batch) != 0 {
+			for _, m := range batch {
+				ids = append(ids, m.Id)
+				images = append(images, imageIds(m.Images)...)
+			}
+			err = s.cs.Prune(ids)
+		}
+		s.m.Unlock()
+
+		if err != nil || len(ids) == 0 {
+			return removed, released, err
+		}
+
+		s.releaseImages(images)
+		for _, id := range ids {
+			if err = s.ss.Remove(id); err != nil {
+				slog.Error("unable to remove message from search index", "message", id, "error", err)
+			}
+		}
+		removed += len(ids)
+		released += len(images)
+	}
+}
+
+// expired returns the next batch of a room's messages to prune, or none if
+// the room is gone or under legal hold. Callers must hold the service lock.
+func (s *ChatService) expired(roomId string, p cicada.Retention) ([]cicada.ChatMessage, error) {
+	r, err := s.rs.Get(roomId)
+	if err != nil || r.LegalHold {
+		return nil, nil
+	}
+
+	var batch []cicada.ChatMessage
+	if p.MaxAge > 0 {
+		batch, err = s.cs.OlderThan(roomId, time.Now().Add(-p.MaxAge), s.opts.PruneBatchSize)
+	}
+	if err == nil && len(batch) == 0 && p.MaxMessages > 0 {
+		batch, err = s.cs.BeyondNewest(roomId, p.MaxMessages, s.opts.PruneBatchSize)
+	}
+	return batch, err
+}
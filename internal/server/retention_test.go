@@ -0,0 +1,61 @@
+package server
+
+import (
+	"cicada"
+	"errors"
+	uuid "github.com/satori/go.uuid"
+	"testing"
+	"time"
+)
+
+func TestPruneThreadParent(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Retention = cicada.Retention{MaxAge: time.Hour}
+	s, done := service(opts)
+	defer done()
+
+	owner := newUser(s, "owner")
+	r := newRoom(t, s, owner)
+
+	// a thread started before the retention limit, still being replied to
+	parent := cicada.ChatMessage{
+		Id:     uuid.NewV4().String(),
+		Date:   time.Now().Add(-2 * time.Hour),
+		RoomId: r.Id,
+		Sender: owner,
+		Text:   "old news",
+	}
+	if err := s.cs.Save(parent); err != nil {
+		t.Fatal("unable to save message", err)
+	}
+	reply, err := s.SendMessage(cicada.ChatMessage{RoomId: r.Id, Sender: owner, Text: "still talking", ParentId: parent.Id})
+	if err != nil {
+		t.Fatal("unable to reply", err)
+	}
+
+	report, err := s.PruneMessages()
+	if err != nil {
+		t.Fatal("unable to prune messages", err)
+	}
+	if report.Rooms != 1 || report.Messages != 1 {
+		t.Errorf("expected only the parent to be pruned, got %+v", report)
+	}
+
+	if _, err = s.cs.Get(parent.Id); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected the parent to be pruned, got", err)
+	}
+	if _, err = s.cs.Get(reply.Id); err != nil {
+		t.Error("expected the reply to be kept until it expires itself", err)
+	}
+	if _, err = s.Thread(owner, parent.Id, "", 0); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected not found reading a pruned thread, got", err)
+	}
+	_, err = s.SendMessage(cicada.ChatMessage{RoomId: r.Id, Sender: owner, Text: "hello?", ParentId: parent.Id})
+	if !errors.Is(err, cicada.ErrorBadRequest) {
+		t.Error("expected bad request replying to a pruned message, got", err)
+	}
+
+	if report, err = s.PruneMessages(); err != nil || report.Messages != 0 {
+		t.Errorf("expected nothing left to prune, got %+v %v", report, err)
+	}
+}
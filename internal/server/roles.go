@@ -0,0 +1,248 @@
+package server
+
+import (
+	"cicada"
+	"context"
+	"fmt"
+	"slices"
+)
+
+// Permission is something a member may be allowed to do in a room.
+type Permission string
+
+const (
+	// PermPost allows sending messages, reacting and editing one's own messages.
+	PermPost Permission = "post"
+	// PermInvite allows issuing invites to a private room. Any member may
+	// invite to an invite-only room.
+	PermInvite Permission = "invite"
+	// PermRename allows changing the room's name.
+	PermRename Permission = "rename"
+	// PermDescribe allows changing the room's description.
+	PermDescribe Permission = "describe"
+	// PermKick allows removing a member from the room.
+	PermKick Permission = "kick"
+	// PermBan allows removing a member and keeping them out.
+	PermBan Permission = "ban"
+	// PermMute allows stopping a member from posting.
+	PermMute Permission = "mute"
+	// PermEditMessages allows editing messages sent by others.
+	PermEditMessages Permission = "edit_messages"
+	// PermDeleteMessages allows deleting messages sent by others.
+	PermDeleteMessages Permission = "delete_messages"
+	// PermPromote allows making members moderators and back.
+	PermPromote Permission = "promote"
+	// PermTransfer allows handing ownership of the room to another member.
+	PermTransfer Permission = "transfer"
+	// PermRetention allows limiting how long the room's messages are kept.
+	PermRetention Permission = "retention"
+)
+
+// rolePermissions lists what each role may do. Roles are ordered, so each
+// role may also do everything the roles below it may.
+var rolePermissions = map[string][]Permission{
+	cicada.RoleMember:    {PermPost},
+	cicada.RoleModerator: {PermPost, PermInvite, PermRename, PermDescribe, PermKick, PermBan, PermMute, PermEditMessages, PermDeleteMessages},
+	cicada.RoleOwner:     {PermPost, PermInvite, PermRename, PermDescribe, PermKick, PermBan, PermMute, PermEditMessages, PermDeleteMessages, PermPromote, PermTransfer, PermRetention},
+}
+
+// roleRank orders roles, so moderators can not act against their peers or
+// the owner.
+var roleRank = map[string]int{
+	cicada.RoleMember:    1,
+	cicada.RoleModerator: 2,
+	cicada.RoleOwner:     3,
+}
+
+// authorize checks that a user may do something in a room. Every operation
+// that changes a room or its messages goes through it. Muted members keep
+// their role but may not post.
+func (s *ChatService) authorize(r cicada.Room, userId string, p Permission) error {
+	role := roleOf(r, userId)
+	if len(role) == 0 {
+		return fmt.Errorf("%w: %s is not a member of room %s", cicada.ErrorForbidden, userId, r.Id)
+	}
+
+	if !slices.Contains(rolePermissions[role], p) {
+		return fmt.Errorf("%w: %s may not %s in room %s", cicada.ErrorForbidden, userId, p, r.Id)
+	}
+
+	if p == PermPost {
+		if _, muted, err := s.activeSanction(r.Id, userId, cicada.SanctionMute); err != nil {
+			return err
+		} else if muted {
+			return fmt.Errorf("%w: %s is muted in room %s", cicada.ErrorForbidden, userId, r.Id)
+		}
+	}
+	return nil
+}
+
+// authorizeOver checks that a user may do something to another member, who
+// must have a lower role than theirs.
+func (s *ChatService) authorizeOver(r cicada.Room, userId, targetId string, p Permission) error {
+	if err := s.authorize(r, userId, p); err != nil {
+		return err
+	}
+
+	target := roleOf(r, targetId)
+	if len(target) == 0 {
+		return fmt.Errorf("%w: %s is not a member of room %s", cicada.ErrorBadRequest, targetId, r.Id)
+	}
+	if roleRank[target] >= roleRank[roleOf(r, userId)] {
+		return fmt.Errorf("%w: %s may not %s %s, who is a %s", cicada.ErrorForbidden, userId, p, targetId, target)
+	}
+	return nil
+}
+
+// authorizeAbove checks that a user may act on something another user did,
+// such as a message they sent or a sanction they placed. The other user must
+// have a lower role, but unlike with authorizeOver they may have left the
+// room, after which they no longer rank above anyone.
+func (s *ChatService) authorizeAbove(r cicada.Room, userId, otherId string, p Permission) error {
+	if err := s.authorize(r, userId, p); err != nil {
+		return err
+	}
+
+	other := roleOf(r, otherId)
+	if len(other) != 0 && roleRank[other] >= roleRank[roleOf(r, userId)] {
+		return fmt.Errorf("%w: %s may not %s for %s, who is a %s", cicada.ErrorForbidden, userId, p, otherId, other)
+	}
+	return nil
+}
+
+// roleOf returns a user's role in a room, or "" if they are not a member.
+func roleOf(r cicada.Room, userId string) string {
+	if !slices.Contains(r.Members, userId) {
+		return ""
+	}
+	if role, ok := r.Roles[userId]; ok {
+		return role
+	}
+	return cicada.RoleMember
+}
+
+// UpdateRoom changes a room's name or description. A nil value is left as it
+// is. Renaming and changing the description are separate permissions.
+func (s *ChatService) UpdateRoom(ctx context.Context, userId, roomId string, name, description *string) (cicada.Room, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return cicada.Room{}, err
+	}
+
+	if r.Direct {
+		return cicada.Room{}, fmt.Errorf("%w: direct conversations have no name", cicada.ErrorBadRequest)
+	}
+	changes := make(map[string]string)
+	if name != nil {
+		if err = s.authorize(r, userId, PermRename); err != nil {
+			return cicada.Room{}, err
+		}
+		r.Name = *name
+		changes["name"] = r.Name
+	}
+	if description != nil {
+		if err = s.authorize(r, userId, PermDescribe); err != nil {
+			return cicada.Room{}, err
+		}
+		r.Description = *description
+		changes["description"] = r.Description
+	}
+
+	if err = s.rs.Update(r); err != nil {
+		return cicada.Room{}, err
+	}
+	s.audit(ctx, cicada.AuditEvent{Actor: userId, Action: cicada.AuditRoomUpdate, RoomId: roomId, Changes: changes})
+	if name != nil {
+		go s.announce(roomId, userId+" renamed the room to "+r.Name)
+	}
+	return r, nil
+}
+
+// SetRole makes a member a moderator or a plain member. Only the owner may
+// promote, and ownership itself moves with TransferOwnership.
+func (s *ChatService) SetRole(ctx context.Context, userId, roomId, targetId, role string) (cicada.Room, error) {
+	if role != cicada.RoleModerator && role != cicada.RoleMember {
+		return cicada.Room{}, fmt.Errorf("%w: role must be %s or %s", cicada.ErrorBadRequest, cicada.RoleModerator, cicada.RoleMember)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return cicada.Room{}, err
+	}
+
+	if err = s.authorizeOver(r, userId, targetId, PermPromote); err != nil {
+		return cicada.Room{}, err
+	}
+	if roleOf(r, targetId) == role {
+		return r, nil
+	}
+
+	setRole(&r, targetId, role)
+	if err = s.rs.Update(r); err != nil {
+		return cicada.Room{}, err
+	}
+	s.audit(ctx, cicada.AuditEvent{Actor: userId, Action: cicada.AuditRoomRole, RoomId: roomId, Target: targetId, Changes: map[string]string{"role": role}})
+	go s.announce(roomId, targetId+" is now a "+role)
+	return r, nil
+}
+
+// TransferOwnership hands a room to another member. The previous owner stays
+// on as a moderator.
+func (s *ChatService) TransferOwnership(ctx context.Context, userId, roomId, targetId string) (cicada.Room, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return cicada.Room{}, err
+	}
+
+	if err = s.authorizeOver(r, userId, targetId, PermTransfer); err != nil {
+		return cicada.Room{}, err
+	}
+
+	setRole(&r, userId, cicada.RoleModerator)
+	setRole(&r, targetId, cicada.RoleOwner)
+	if err = s.rs.Update(r); err != nil {
+		return cicada.Room{}, err
+	}
+	s.audit(ctx, cicada.AuditEvent{Actor: userId, Action: cicada.AuditRoomTransfer, RoomId: roomId, Target: targetId, Changes: map[string]string{"owner": targetId}})
+	go s.announce(roomId, targetId+" is now the owner")
+	return r, nil
+}
+
+// succeedOwner picks a new owner when the owner leaves a room: the first
+// moderator to have joined, or else the first member.
+func succeedOwner(r *cicada.Room) string {
+	if len(r.Members) == 0 {
+		return ""
+	}
+
+	heir := r.Members[0]
+	for _, uid := range r.Members {
+		if roleOf(*r, uid) == cicada.RoleModerator {
+			heir = uid
+			break
+		}
+	}
+	setRole(r, heir, cicada.RoleOwner)
+	return heir
+}
+
+// setRole records a member's role. Plain members are not stored.
+func setRole(r *cicada.Room, userId, role string) {
+	if r.Roles == nil {
+		r.Roles = make(map[string]string)
+	}
+	if role == cicada.RoleMember {
+		delete(r.Roles, userId)
+	} else {
+		r.Roles[userId] = role
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"cicada"
+	"context"
+	"errors"
+	"testing"
+)
+
+// room creates a public room owned by the first user, which the others join.
+func newRoom(t *testing.T, s *ChatService, owner string, members ...string) cicada.Room {
+	t.Helper()
+	ctx := context.Background()
+	r, err := s.CreateRoom(ctx, owner, cicada.Room{Name: "Lobby"})
+	if err != nil {
+		t.Fatal("unable to create room", err)
+	}
+	for _, uid := range members {
+		if _, err = s.JoinRoom(ctx, uid, r.Id, ""); err != nil {
+			t.Fatal("unable to join room", err)
+		}
+	}
+	return r
+}
+
+func TestPost(t *testing.T) {
+	s, done := service(DefaultOptions())
+	defer done()
+	ctx := context.Background()
+
+	owner, member, outsider := newUser(s, "owner"), newUser(s, "member"), newUser(s, "outsider")
+	r := newRoom(t, s, owner, member)
+
+	if _, err := s.SendMessage(cicada.ChatMessage{RoomId: r.Id, Sender: member, Text: "hello"}); err != nil {
+		t.Fatal("expected a member to be able to post, got", err)
+	}
+	if _, err := s.SendMessage(cicada.ChatMessage{RoomId: r.Id, Sender: outsider, Text: "hello"}); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected someone outside the room not to post, got", err)
+	}
+
+	if _, err := s.Mute(ctx, owner, r.Id, member, "", 0); err != nil {
+		t.Fatal("unable to mute member", err)
+	}
+	if _, err := s.SendMessage(cicada.ChatMessage{RoomId: r.Id, Sender: member, Text: "hello"}); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected a muted member not to post, got", err)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	s, done := service(DefaultOptions())
+	defer done()
+	ctx := context.Background()
+
+	owner, moderator, member := newUser(s, "owner"), newUser(s, "moderator"), newUser(s, "member")
+	r := newRoom(t, s, owner, moderator, member)
+
+	name := "Renamed"
+	if _, err := s.UpdateRoom(ctx, member, r.Id, &name, nil); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected a member not to rename the room, got", err)
+	}
+	if _, err := s.SetRole(ctx, member, r.Id, moderator, cicada.RoleModerator); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected a member not to promote, got", err)
+	}
+	if _, err := s.SetRole(ctx, owner, r.Id, moderator, cicada.RoleModerator); err != nil {
+		t.Fatal("unable to promote moderator", err)
+	}
+	if _, err := s.UpdateRoom(ctx, moderator, r.Id, &name, nil); err != nil {
+		t.Error("expected a moderator to rename the room, got", err)
+	}
+	if _, err := s.SetRole(ctx, moderator, r.Id, member, cicada.RoleModerator); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected a moderator not to promote, got", err)
+	}
+	if _, err := s.TransferOwnership(ctx, moderator, r.Id, member); !errors.Is(err, cicada.ErrorForbidden) {
+		t.Error("expected a moderator not to transfer ownership, got", err)
+	}
+
+	r, err := s.TransferOwnership(ctx, owner, r.Id, member)
+	if err != nil {
+		t.Fatal("unable to transfer ownership", err)
+	}
+	if roleOf(r, member) != cicada.RoleOwner || roleOf(r, owner) != cicada.RoleModerator {
+		t.Error("expected the owner to step down to moderator, got", r.Roles)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"cicada"
+	"cicada/internal/server/store/search"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Search finds messages in the user's rooms containing every word and quoted
+// phrase of the text, newest first. The sender and dates, when given, narrow
+// the search to one user's messages or to messages sent between them.
+func (s *ChatService) Search(userId, text, sender string, after, before time.Time, limit int) ([]cicada.SearchResult, error) {
+	if limit <= 0 {
+		limit = s.opts.HistoryPageSize
+	}
+	if limit > s.opts.MaxHistoryPageSize {
+		return nil, fmt.Errorf("%w: limit must be at most %d", cicada.ErrorBadRequest, s.opts.MaxHistoryPageSize)
+	}
+
+	rooms, err := s.rs.GetForUser(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	q := search.ParseQuery(text)
+	q.Rooms = make(map[string]bool, len(rooms))
+	for _, r := range rooms {
+		q.Rooms[r.Id] = true
+	}
+	q.Sender = sender
+	q.After = after
+	q.Before = before
+	q.Limit = limit
+
+	hits, err := s.ss.Search(q)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]cicada.SearchResult, 0, len(hits))
+	for _, h := range hits {
+		m, err := s.cs.Get(h.MessageId)
+		if errors.Is(err, cicada.ErrorNotFound) {
+			// the message went away after it was found
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if !m.Deleted {
+			results = append(results, cicada.SearchResult{Message: m, Snippet: search.Snippet(m.Text, q.Terms())})
+		}
+	}
+	return results, nil
+}
+
+// RebuildSearchIndex indexes every stored message from scratch, and returns
+// how many messages were indexed. Messages sent while it runs may be lost
+// from the index, so it is meant to run before the server starts serving.
+func (s *ChatService) RebuildSearchIndex() (int, error) {
+	if err := s.ss.Clear(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err := s.cs.ForEach(func(m cicada.ChatMessage) error {
+		if m.Deleted {
+			return nil
+		}
+		count++
+		return s.ss.Index(m)
+	})
+	return count, err
+}
+
+// index updates the search index after a message is saved, edited or
+// deleted. A failure leaves the message out of searches until the index is
+// rebuilt.
+func (s *ChatService) index(m cicada.ChatMessage) {
+	if err := s.ss.Index(m); err != nil {
+		slog.Error("unable to index message", "message", m.Id, "error", err)
+	}
+}
+
+// unindexRoom drops a deleted room's messages from the search index.
+func (s *ChatService) unindexRoom(roomId string) {
+	if err := s.ss.RemoveRoom(roomId); err != nil {
+		slog.Error("unable to remove room from search index", "room", roomId, "error", err)
+	}
+}
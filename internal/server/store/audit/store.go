@@ -0,0 +1,104 @@
+// Package audit keeps the audit log. The log is append-only: the store has no
+// way to change or delete an event once it is recorded.
+package audit
+
+import (
+	"cicada"
+	"github.com/ostafen/clover/v2"
+	"github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/query"
+	uuid "github.com/satori/go.uuid"
+	"log"
+	"time"
+)
+
+const (
+	collection = "audit"
+)
+
+// Query selects audit events. Empty fields match every event, and From and
+// To bound the event date, inclusively.
+type Query struct {
+	Actor  string
+	RoomId string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+type Store struct {
+	db *clover.DB
+}
+
+func NewStore(db *clover.DB) *Store {
+	exists, err := db.HasCollection(collection)
+	if err != nil {
+		log.Fatal("failed to create collection", collection, err)
+	}
+
+	if !exists {
+		err := db.CreateCollection(collection)
+		if err != nil {
+			log.Fatal("failed to create collection", collection, err)
+		}
+
+		for _, field := range []string{"date", "actor", "roomId"} {
+			err = db.CreateIndex(collection, field)
+			if err != nil {
+				log.Fatal("failed to create "+field+" index for collection:", collection, err)
+			}
+		}
+	}
+	return &Store{db: db}
+}
+
+// Append records an event, giving it an id.
+func (s *Store) Append(e cicada.AuditEvent) (cicada.AuditEvent, error) {
+	e.Id = uuid.NewV4().String()
+	return e, s.db.Insert(collection, document.NewDocumentOf(e))
+}
+
+// Find returns the events matching a query, newest first.
+func (s *Store) Find(q Query) ([]cicada.AuditEvent, error) {
+	if q.Limit <= 0 {
+		return nil, cicada.ErrorBadRequest
+	}
+
+	var criteria []query.Criteria
+	if len(q.Actor) != 0 {
+		criteria = append(criteria, query.Field("actor").Eq(q.Actor))
+	}
+	if len(q.RoomId) != 0 {
+		criteria = append(criteria, query.Field("roomId").Eq(q.RoomId))
+	}
+	if !q.From.IsZero() {
+		criteria = append(criteria, query.Field("date").GtEq(q.From))
+	}
+	if !q.To.IsZero() {
+		// LtEq misses events dated exactly To when the date index is used
+		criteria = append(criteria, query.Field("date").Lt(q.To.Add(time.Nanosecond)))
+	}
+
+	cq := query.NewQuery(collection)
+	if len(criteria) != 0 {
+		c := criteria[0]
+		for _, other := range criteria[1:] {
+			c = c.And(other)
+		}
+		cq = cq.Where(c)
+	}
+	cq = cq.Sort(query.SortOption{Field: "date", Direction: -1}).Limit(q.Limit)
+
+	docs, err := s.db.FindAll(cq)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]cicada.AuditEvent, len(docs))
+	for i, d := range docs {
+		if err = d.Unmarshal(&events[i]); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
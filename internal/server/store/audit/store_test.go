@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"cicada"
+	"github.com/ostafen/clover/v2"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func database() (*clover.DB, string) {
+	dbDir := os.TempDir()
+	slog.Info("using temp dir", "dir", dbDir)
+	db, err := clover.Open(dbDir)
+	if err != nil {
+		log.Fatal("unable to open database", err)
+	}
+	return db, dbDir
+}
+
+func TestFind(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	store := NewStore(db)
+
+	start := time.Now().Add(-time.Hour).Round(0)
+	events := []cicada.AuditEvent{
+		{Date: start, Actor: "user1", Action: cicada.AuditUserCreate, Source: "10.0.0.1"},
+		{Date: start.Add(time.Minute), Actor: "user1", Action: cicada.AuditRoomCreate, RoomId: "room1", Changes: map[string]string{"name": "Lobby"}},
+		{Date: start.Add(2 * time.Minute), Actor: "user2", Action: cicada.AuditRoomJoin, RoomId: "room1"},
+		{Date: start.Add(3 * time.Minute), Actor: "user1", Action: cicada.AuditRoomRole, RoomId: "room1", Target: "user2"},
+		{Date: start.Add(4 * time.Minute), Actor: "user2", Action: cicada.AuditRoomCreate, RoomId: "room2"},
+	}
+	for i, e := range events {
+		stored, err := store.Append(e)
+		if err != nil {
+			t.Fatal("unable to append event", err)
+		}
+		if len(stored.Id) == 0 {
+			t.Fatal("appended event was not given an id")
+		}
+		events[i] = stored
+	}
+
+	tests := []struct {
+		q        Query
+		expected []int
+	}{
+		{Query{Limit: 10}, []int{4, 3, 2, 1, 0}},
+		{Query{Limit: 2}, []int{4, 3}},
+		{Query{Actor: "user1", Limit: 10}, []int{3, 1, 0}},
+		{Query{RoomId: "room1", Limit: 10}, []int{3, 2, 1}},
+		{Query{Actor: "user2", RoomId: "room1", Limit: 10}, []int{2}},
+		{Query{From: start.Add(time.Minute), To: start.Add(3 * time.Minute), Limit: 10}, []int{3, 2, 1}},
+		{Query{Actor: "user1", From: start.Add(30 * time.Second), Limit: 1}, []int{3}},
+		{Query{Actor: "user3", Limit: 10}, []int{}},
+	}
+	for _, test := range tests {
+		found, err := store.Find(test.q)
+		if err != nil {
+			t.Fatal("error finding events", err)
+		}
+		if len(found) != len(test.expected) {
+			t.Errorf("query %+v: expected %d events, got %d", test.q, len(test.expected), len(found))
+			continue
+		}
+		for i, e := range found {
+			if e.Id != events[test.expected[i]].Id {
+				t.Errorf("query %+v: expected event %d at %d, got %+v", test.q, test.expected[i], i, e)
+			}
+		}
+	}
+
+	found, err := store.Find(Query{Actor: "user1", RoomId: "room1", Limit: 1})
+	if err != nil || len(found) != 1 {
+		t.Fatal("error finding events", err)
+	}
+	if found[0].Target != "user2" || found[0].Action != cicada.AuditRoomRole {
+		t.Error("event did not round trip", found[0])
+	}
+
+	if _, err = store.Find(Query{}); err == nil {
+		t.Error("expected an error without a limit")
+	}
+}
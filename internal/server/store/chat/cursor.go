@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"cicada"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cursor marks a position in a room's history. Messages are ordered by date,
+// and messages with the same date by id, so a cursor is stable even when
+// several messages share a timestamp.
+type Cursor struct {
+	Date time.Time
+	Id   string
+}
+
+// CursorOf returns the cursor positioned at a message.
+func CursorOf(m cicada.ChatMessage) Cursor {
+	return Cursor{Date: m.Date, Id: m.Id}
+}
+
+// CursorBefore returns a cursor that reading after includes m. Messages sent
+// in the nanosecond before m may be included too.
+func CursorBefore(m cicada.ChatMessage) Cursor {
+	return Cursor{Date: m.Date.Add(-time.Nanosecond), Id: m.Id}
+}
+
+// String encodes the cursor as an opaque token for clients.
+func (c Cursor) String() string {
+	raw := strconv.FormatInt(c.Date.UnixNano(), 10) + ":" + c.Id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// IsZero reports whether the cursor is unset.
+func (c Cursor) IsZero() bool {
+	return c.Date.IsZero() && len(c.Id) == 0
+}
+
+// ParseCursor decodes a token produced by Cursor.String.
+func ParseCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: malformed cursor", cicada.ErrorBadRequest)
+	}
+
+	nanos, id, found := strings.Cut(string(raw), ":")
+	if !found || len(id) == 0 {
+		return Cursor{}, fmt.Errorf("%w: malformed cursor", cicada.ErrorBadRequest)
+	}
+
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: malformed cursor", cicada.ErrorBadRequest)
+	}
+	return Cursor{Date: time.Unix(0, n), Id: id}, nil
+}
+
+// before reports whether message m sorts before the cursor.
+func (c Cursor) before(m cicada.ChatMessage) bool {
+	return m.Date.Before(c.Date) || (m.Date.Equal(c.Date) && m.Id < c.Id)
+}
+
+// after reports whether message m sorts after the cursor.
+func (c Cursor) after(m cicada.ChatMessage) bool {
+	return m.Date.After(c.Date) || (m.Date.Equal(c.Date) && m.Id > c.Id)
+}
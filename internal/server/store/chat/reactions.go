@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"cicada"
+	"github.com/ostafen/clover/v2/document"
+	"slices"
+)
+
+// React adds or removes a user's reaction to a message. A user has at most
+// one of each emoji on a message, so repeating a change does nothing. The
+// stored message is returned along with whether it changed.
+func (s *Store) React(messageId, emoji, userId string, add bool) (cicada.ChatMessage, bool, error) {
+	m := cicada.ChatMessage{}
+	changed := false
+	var err error
+	e := s.db.UpdateById(collection, messageId, func(doc *document.Document) *document.Document {
+		changed = false
+		m = cicada.ChatMessage{}
+		if err = doc.Unmarshal(&m); err != nil {
+			return doc
+		}
+
+		users := m.Reactions[emoji]
+		if add == slices.Contains(users, userId) {
+			return doc
+		}
+
+		if add {
+			users = append(users, userId)
+		} else {
+			users = slices.DeleteFunc(users, func(uid string) bool { return uid == userId })
+		}
+		setReaction(&m, emoji, users)
+		changed = true
+		return messageDocument(m)
+	})
+
+	if err != nil {
+		return cicada.ChatMessage{}, false, err
+	}
+	if e = processError(e); e != nil {
+		return cicada.ChatMessage{}, false, e
+	}
+	return m, changed, nil
+}
+
+// setReaction records the users with a reaction, dropping emojis no one
+// has used so the maps stay small.
+func setReaction(m *cicada.ChatMessage, emoji string, users []string) {
+	if len(users) == 0 {
+		delete(m.Reactions, emoji)
+		delete(m.ReactionCounts, emoji)
+		return
+	}
+
+	if m.Reactions == nil {
+		m.Reactions = make(map[string][]string)
+	}
+	if m.ReactionCounts == nil {
+		m.ReactionCounts = make(map[string]int)
+	}
+	m.Reactions[emoji] = users
+	m.ReactionCounts[emoji] = len(users)
+}
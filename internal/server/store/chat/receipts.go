@@ -0,0 +1,124 @@
+package chat
+
+import (
+	"cicada"
+	"errors"
+	"github.com/ostafen/clover/v2"
+	"github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/query"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	receipts = "receipts"
+)
+
+// receiptNamespace derives the document id of the receipt for a user and
+// room, so each pair has at most one receipt.
+var receiptNamespace = uuid.Must(uuid.FromString("6f0b6f4e-3c55-4b8e-9a7e-2d1f5c0a9b21"))
+
+// MarkRead moves a user's read marker in a room forward to r. A marker is
+// never moved back, so the stored receipt is returned along with whether it
+// changed.
+func (s *Store) MarkRead(r cicada.ReadReceipt) (cicada.ReadReceipt, bool, error) {
+	id := receiptId(r.UserId, r.RoomId)
+	doc := document.NewDocumentOf(r)
+	doc.Set(document.ObjectIdField, id)
+
+	current := r
+	moved := false
+	update := func(old *document.Document) *document.Document {
+		stored := cicada.ReadReceipt{}
+		if err := old.Unmarshal(&stored); err != nil || CursorOfReceipt(stored).after(messageOf(r)) {
+			moved = true
+			current = r
+			return doc
+		}
+		moved = false
+		current = stored
+		return old
+	}
+
+	err := s.db.UpdateById(receipts, id, update)
+	if errors.Is(err, clover.ErrDocumentNotExist) {
+		err = s.db.Insert(receipts, doc)
+		moved = err == nil
+		if errors.Is(err, clover.ErrDuplicateKey) {
+			// another request created the receipt first
+			err = s.db.UpdateById(receipts, id, update)
+		}
+	}
+
+	if err != nil {
+		return cicada.ReadReceipt{}, false, processError(err)
+	}
+	return current, moved, nil
+}
+
+// LastRead returns a user's read marker in a room.
+func (s *Store) LastRead(userId, roomId string) (cicada.ReadReceipt, error) {
+	r := cicada.ReadReceipt{}
+	doc, err := s.db.FindById(receipts, receiptId(userId, roomId))
+	if e := processError(err); e != nil {
+		return r, e
+	}
+
+	if doc == nil {
+		return r, cicada.ErrorNotFound
+	}
+
+	err = doc.Unmarshal(&r)
+	return r, err
+}
+
+// DeleteReceipt removes a user's read marker in a room.
+func (s *Store) DeleteReceipt(userId, roomId string) error {
+	err := s.db.DeleteById(receipts, receiptId(userId, roomId))
+	if errors.Is(err, clover.ErrDocumentNotExist) {
+		return nil
+	}
+	return processError(err)
+}
+
+// CountAfter counts the messages in a room's main timeline after a cursor
+// that were not sent by the given user. A zero cursor counts every message.
+func (s *Store) CountAfter(roomId string, c Cursor, except string) (int, error) {
+	from := c.Date
+	if c.IsZero() {
+		from = startOfTime
+	}
+
+	q := query.NewQuery(collection).Where(inRoom(roomId, from, endOfTime))
+
+	count := 0
+	var err error
+	e := s.db.ForEach(q, func(doc *document.Document) bool {
+		m := cicada.ChatMessage{}
+		if err = doc.Unmarshal(&m); err != nil {
+			return false
+		}
+
+		if m.Sender != except && len(m.ParentId) == 0 && (c.IsZero() || c.after(m)) {
+			count++
+		}
+		return true
+	})
+
+	if err != nil {
+		return 0, err
+	}
+	return count, processError(e)
+}
+
+// CursorOfReceipt returns the position of a read marker in the room's history.
+func CursorOfReceipt(r cicada.ReadReceipt) Cursor {
+	return Cursor{Date: r.Date, Id: r.MessageId}
+}
+
+func messageOf(r cicada.ReadReceipt) cicada.ChatMessage {
+	return cicada.ChatMessage{Id: r.MessageId, Date: r.Date}
+}
+
+func receiptId(userId, roomId string) string {
+	return uuid.NewV5(receiptNamespace, userId+"/"+roomId).String()
+}
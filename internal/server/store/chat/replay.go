@@ -0,0 +1,80 @@
+package chat
+
+import (
+	"cicada"
+	"github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/query"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Since fetches up to size messages sent to any of the given rooms after a
+// cursor, oldest first. Thread replies are included. A zero cursor reads from
+// the oldest message.
+//
+// Each room is read with a range scan on the roomDate index that stops once
+// the room has filled a page, so the cost grows with the number of rooms and
+// the page size, never with the traffic of rooms the user is not in.
+func (s *Store) Since(roomIds []string, c Cursor, size int) ([]cicada.ChatMessage, error) {
+	if size <= 0 {
+		return nil, cicada.ErrorBadRequest
+	}
+
+	from := c.Date
+	if c.IsZero() {
+		from = startOfTime
+	}
+
+	var messages []cicada.ChatMessage
+	for _, roomId := range roomIds {
+		page, err := s.since(roomId, c, from, size)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, page...)
+	}
+
+	slices.SortStableFunc(messages, func(a, b cicada.ChatMessage) int {
+		if d := a.Date.Compare(b.Date); d != 0 {
+			return d
+		}
+		return strings.Compare(a.Id, b.Id)
+	})
+	if len(messages) > size {
+		messages = messages[:size]
+	}
+	return messages, nil
+}
+
+// since reads up to size messages of one room from a date on, plus any that
+// tie with the last of them.
+func (s *Store) since(roomId string, c Cursor, from time.Time, size int) ([]cicada.ChatMessage, error) {
+	q := query.NewQuery(collection).
+		Where(inRoom(roomId, from, endOfTime)).
+		Sort(query.SortOption{Field: "roomDate", Direction: 1})
+
+	var messages []cicada.ChatMessage
+	var err error
+	e := s.db.ForEach(q, func(doc *document.Document) bool {
+		m := cicada.ChatMessage{}
+		if err = doc.Unmarshal(&m); err != nil {
+			return false
+		}
+
+		// read past a full page while dates tie, since the index orders
+		// messages with the same date arbitrarily
+		if len(messages) >= size && m.Date.After(messages[len(messages)-1].Date) {
+			return false
+		}
+		if c.IsZero() || c.after(m) {
+			messages = append(messages, m)
+		}
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return messages, processError(e)
+}
@@ -0,0 +1,67 @@
+package chat
+
+import (
+	"cicada"
+	"github.com/ostafen/clover/v2/query"
+	"time"
+)
+
+// OlderThan returns up to size of a room's oldest messages dated before the
+// given time, oldest first. Replies are included.
+func (s *Store) OlderThan(roomId string, before time.Time, size int) ([]cicada.ChatMessage, error) {
+	if size <= 0 {
+		return nil, cicada.ErrorBadRequest
+	}
+
+	q := query.NewQuery(collection).
+		Where(inRoom(roomId, startOfTime, before)).
+		Sort(query.SortOption{Field: "roomDate", Direction: 1}).
+		Limit(size)
+	return s.find(q)
+}
+
+// BeyondNewest returns up to size of the messages in a room that come after
+// its newest keep messages, newest first. Replies are included and counted.
+func (s *Store) BeyondNewest(roomId string, keep, size int) ([]cicada.ChatMessage, error) {
+	if keep < 0 || size <= 0 {
+		return nil, cicada.ErrorBadRequest
+	}
+
+	// the skipped messages are read from the room's slice of the roomDate
+	// index only, so a sweep costs the room's size, not the server's
+	q := query.NewQuery(collection).
+		Where(inRoom(roomId, startOfTime, endOfTime)).
+		Sort(query.SortOption{Field: "roomDate", Direction: -1}).
+		Skip(keep).
+		Limit(size)
+	return s.find(q)
+}
+
+// Prune deletes messages along with their revisions. Their images must be
+// released by the caller.
+func (s *Store) Prune(ids []string) error {
+	for _, id := range ids {
+		if err := s.db.DeleteById(collection, id); err != nil {
+			return processError(err)
+		}
+		if err := s.DeleteRevisions(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) find(q *query.Query) ([]cicada.ChatMessage, error) {
+	docs, err := s.db.FindAll(q)
+	if err != nil {
+		return nil, processError(err)
+	}
+
+	messages := make([]cicada.ChatMessage, len(docs))
+	for i, d := range docs {
+		if err = d.Unmarshal(&messages[i]); err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
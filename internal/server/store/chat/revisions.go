@@ -0,0 +1,43 @@
+package chat
+
+import (
+	"cicada"
+	"github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/query"
+)
+
+const (
+	revisions = "revisions"
+)
+
+// SaveRevision keeps the text a message had before it was edited.
+func (s *Store) SaveRevision(r cicada.Revision) error {
+	return s.db.Insert(revisions, document.NewDocumentOf(r))
+}
+
+// Revisions lists the earlier texts of a message, oldest first.
+func (s *Store) Revisions(messageId string) ([]cicada.Revision, error) {
+	q := query.NewQuery(revisions).
+		Where(query.Field("messageId").Eq(messageId)).
+		Sort(query.SortOption{Field: "date", Direction: 1})
+
+	docs, err := s.db.FindAll(q)
+	if err != nil {
+		return nil, processError(err)
+	}
+
+	result := make([]cicada.Revision, len(docs))
+	for i, doc := range docs {
+		if err = doc.Unmarshal(&result[i]); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// DeleteRevisions removes the earlier texts of a message.
+func (s *Store) DeleteRevisions(messageId string) error {
+	q := query.NewQuery(revisions).
+		Where(query.Field("messageId").Eq(messageId))
+	return processError(s.db.Delete(q))
+}
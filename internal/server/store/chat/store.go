@@ -3,46 +3,193 @@ package chat
 import (
 	"cicada"
 	"errors"
+	"fmt"
 	"github.com/ostafen/clover/v2"
 	"github.com/ostafen/clover/v2/document"
 	"github.com/ostafen/clover/v2/query"
 	"log"
+	"math"
+	"time"
 )
 
 const (
 	collection = "chats"
 )
 
+// Direction selects which side of a cursor a page is read from.
+type Direction int
+
+const (
+	// Before pages toward older messages, newest first.
+	Before Direction = iota
+	// After pages toward newer messages, oldest first.
+	After
+)
+
+// clover indexes dates by their UnixNano value, so scans are bounded by the
+// range of times that can be represented that way.
+var (
+	startOfTime = time.Unix(0, 0)
+	endOfTime   = time.Unix(0, math.MaxInt64)
+)
+
 type Store struct {
 	db *clover.DB
 }
 
 func NewStore(db *clover.DB) *Store {
-	exists, err := db.HasCollection(collection)
+	createCollection(db, collection, "roomId", "date", "parentId", "roomDate")
+	backfillRoomDate(db)
+	createCollection(db, receipts, "roomId")
+	createCollection(db, revisions, "roomId", "messageId")
+	return &Store{db: db}
+}
+
+// createCollection creates a collection if it does not exist, and an index on
+// each of the given fields that does not have one yet, so indexes added in
+// later versions are built for existing databases too.
+func createCollection(db *clover.DB, name string, fields ...string) {
+	exists, err := db.HasCollection(name)
 	if err != nil {
-		log.Fatal("failed to create collection", collection, err)
+		log.Fatal("failed to create collection", name, err)
 	}
 
 	if !exists {
-		err := db.CreateCollection(collection)
+		err := db.CreateCollection(name)
 		if err != nil {
-			log.Fatal("failed to create collection", collection, err)
+			log.Fatal("failed to create collection", name, err)
 		}
-		err = db.CreateIndex(collection, "roomId")
-		if err != nil {
-			log.Fatal("failed to create roomId index for collection:", collection, err)
+	}
+
+	for _, field := range fields {
+		indexed, err := db.HasIndex(name, field)
+		if err == nil && !indexed {
+			err = db.CreateIndex(name, field)
 		}
-		err = db.CreateIndex(collection, "date")
 		if err != nil {
-			log.Fatal("failed to create date index for collection:", collection, err)
+			log.Fatal("failed to create "+field+" index for collection:", name, err)
 		}
 	}
-	return &Store{db: db}
 }
 
-func (s *Store) Save(m cicada.ChatMessage) error {
+// roomDateKey orders a room's messages by date under one indexed string, so a
+// single range scan reads one room from a point in time without touching the
+// rest of the server.
+func roomDateKey(roomId string, date time.Time) string {
+	return fmt.Sprintf("%s/%019d", roomId, date.UnixNano())
+}
+
+// inRoom matches the messages of a room dated from from, inclusive, until
+// until, exclusive. Both bounds are on the roomDate index, so clover scans
+// only that room's slice of it.
+func inRoom(roomId string, from, until time.Time) query.Criteria {
+	return query.Field("roomDate").GtEq(roomDateKey(roomId, from)).
+		And(query.Field("roomDate").Lt(roomDateKey(roomId, until)))
+}
+
+// messageDocument converts a message to a document carrying its roomDate key.
+// Every write to the collection must go through it.
+func messageDocument(m cicada.ChatMessage) *document.Document {
 	doc := document.NewDocumentOf(m)
-	return s.db.Insert(collection, doc)
+	doc.Set("roomDate", roomDateKey(m.RoomId, m.Date))
+	return doc
+}
+
+// backfillRoomDate sets the roomDate key on messages stored before it existed.
+func backfillRoomDate(db *clover.DB) {
+	q := query.NewQuery(collection).MatchFunc(func(doc *document.Document) bool {
+		return !doc.Has("roomDate")
+	})
+	err := db.UpdateFunc(q, func(doc *document.Document) *document.Document {
+		m := cicada.ChatMessage{}
+		if err := doc.Unmarshal(&m); err != nil {
+			return doc
+		}
+		return messageDocument(m)
+	})
+	if err != nil {
+		log.Fatal("failed to backfill roomDate for collection:", collection, err)
+	}
+}
+
+func (s *Store) Save(m cicada.ChatMessage) error {
+	return s.db.Insert(collection, messageDocument(m))
+}
+
+// Get fetches a single message by id.
+func (s *Store) Get(id string) (cicada.ChatMessage, error) {
+	m := cicada.ChatMessage{}
+	doc, err := s.db.FindById(collection, id)
+	if e := processError(err); e != nil {
+		return m, e
+	}
+
+	if doc == nil {
+		return m, cicada.ErrorNotFound
+	}
+
+	err = doc.Unmarshal(&m)
+	return m, err
+}
+
+// Page fetches up to size messages from a room's main timeline on one side of
+// a cursor, nearest to the cursor first. Thread replies are left out. A zero
+// cursor pages from the newest message when reading Before, and from the
+// oldest when reading After.
+//
+// The query walks the room's slice of the roomDate index from the cursor and
+// stops as soon as the page is full, so the cost grows with neither how far
+// back the cursor is nor the traffic of other rooms.
+func (s *Store) Page(roomId string, c Cursor, dir Direction, size int) ([]cicada.ChatMessage, error) {
+	if size <= 0 {
+		return nil, cicada.ErrorBadRequest
+	}
+
+	var q *query.Query
+	var include func(m cicada.ChatMessage) bool
+	if dir == Before {
+		// a reverse index scan skips entries equal to an inclusive upper bound,
+		// so use an exclusive bound just past the cursor instead
+		until := endOfTime
+		if !c.IsZero() {
+			until = c.Date.Add(time.Nanosecond)
+		}
+		q = query.NewQuery(collection).
+			Where(inRoom(roomId, startOfTime, until)).
+			Sort(query.SortOption{Field: "roomDate", Direction: -1})
+		include = func(m cicada.ChatMessage) bool { return c.IsZero() || c.before(m) }
+	} else {
+		from := c
+		if from.IsZero() {
+			from = Cursor{Date: startOfTime}
+		}
+		q = query.NewQuery(collection).
+			Where(inRoom(roomId, from.Date, endOfTime)).
+			Sort(query.SortOption{Field: "roomDate", Direction: 1})
+		include = func(m cicada.ChatMessage) bool { return c.IsZero() || c.after(m) }
+	}
+
+	messages := make([]cicada.ChatMessage, 0, size)
+	var err error
+	e := s.db.ForEach(q, func(doc *document.Document) bool {
+		m := cicada.ChatMessage{}
+		if err = doc.Unmarshal(&m); err != nil {
+			return false
+		}
+
+		if len(m.ParentId) == 0 && include(m) {
+			messages = append(messages, m)
+		}
+		return len(messages) < size
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	if e = processError(e); e != nil {
+		return nil, e
+	}
+	return messages, nil
 }
 
 // GetWindow fetches a page of chat messages, sorted by date.
@@ -72,10 +219,79 @@ func (s *Store) GetWindow(roomId string, from, size int) ([]cicada.ChatMessage,
 	return messages, nil
 }
 
-func (s *Store) Delete(roomId string) error {
+// ForEach calls fn with every stored message, stopping at the first error.
+func (s *Store) ForEach(fn func(m cicada.ChatMessage) error) error {
+	var err error
+	e := s.db.ForEach(query.NewQuery(collection), func(doc *document.Document) bool {
+		m := cicada.ChatMessage{}
+		if err = doc.Unmarshal(&m); err == nil {
+			err = fn(m)
+		}
+		return err == nil
+	})
+
+	if err != nil {
+		return err
+	}
+	return processError(e)
+}
+
+// ImageIds lists the images attached to messages in a room, once for each
+// message that references them.
+func (s *Store) ImageIds(roomId string) ([]string, error) {
+	ids := []string{}
 	q := query.NewQuery(collection).
 		Where(query.Field("roomId").Eq(roomId))
-	return processError(s.db.Delete(q))
+	err := s.forEachImage(q, func(id string) {
+		ids = append(ids, id)
+	})
+	return ids, err
+}
+
+// ReferencedImages returns the set of images attached to any message.
+func (s *Store) ReferencedImages() (map[string]bool, error) {
+	live := make(map[string]bool)
+	err := s.forEachImage(query.NewQuery(collection), func(id string) {
+		live[id] = true
+	})
+	return live, err
+}
+
+func (s *Store) forEachImage(q *query.Query, fn func(id string)) error {
+	var err error
+	e := s.db.ForEach(q, func(doc *document.Document) bool {
+		m := cicada.ChatMessage{}
+		if err = doc.Unmarshal(&m); err != nil {
+			return false
+		}
+
+		for _, img := range m.Images {
+			fn(img.Id)
+		}
+		return true
+	})
+
+	if err != nil {
+		return err
+	}
+	return processError(e)
+}
+
+// Replace overwrites a stored message, such as after it is edited.
+func (s *Store) Replace(m cicada.ChatMessage) error {
+	return processError(s.db.ReplaceById(collection, m.Id, messageDocument(m)))
+}
+
+// Delete removes a room's messages, their revisions and the room's read
+// markers.
+func (s *Store) Delete(roomId string) error {
+	var err error
+	for _, name := range []string{collection, revisions, receipts} {
+		if err = s.db.Delete(query.NewQuery(name).Where(query.Field("roomId").Eq(roomId))); err != nil {
+			break
+		}
+	}
+	return processError(err)
 }
 
 func processError(e error) error {
@@ -2,13 +2,16 @@ package chat
 
 import (
 	"cicada"
+	"errors"
 	"fmt"
 	"github.com/ostafen/clover/v2"
+	"github.com/ostafen/clover/v2/document"
 	uuid "github.com/satori/go.uuid"
 	"log"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"testing"
 	"time"
@@ -144,3 +147,562 @@ func generateMessages(roomId string, count int) []cicada.ChatMessage {
 	}
 	return messages
 }
+
+func TestPageBefore(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	s := NewStore(db)
+	messages := generateMessages("237", 10)
+	for _, m := range append(messages, generateMessages("238", 5)...) {
+		if err := s.Save(m); err != nil {
+			t.Fatal("unable to save messages", err)
+		}
+	}
+
+	page, err := s.Page("237", Cursor{}, Before, 4)
+	if err != nil {
+		t.Fatal("unable to get latest page", err)
+	}
+	expectTexts(t, page, messages[9], messages[8], messages[7], messages[6])
+
+	page, err = s.Page("237", CursorOf(page[3]), Before, 4)
+	if err != nil {
+		t.Fatal("unable to get page before cursor", err)
+	}
+	expectTexts(t, page, messages[5], messages[4], messages[3], messages[2])
+
+	page, err = s.Page("237", CursorOf(page[3]), Before, 4)
+	if err != nil {
+		t.Fatal("unable to get page before cursor", err)
+	}
+	expectTexts(t, page, messages[1], messages[0])
+}
+
+func TestPageAfter(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	s := NewStore(db)
+	messages := generateMessages("237", 10)
+	for _, m := range messages {
+		if err := s.Save(m); err != nil {
+			t.Fatal("unable to save messages", err)
+		}
+	}
+
+	page, err := s.Page("237", Cursor{}, After, 3)
+	if err != nil {
+		t.Fatal("unable to get oldest page", err)
+	}
+	expectTexts(t, page, messages[0], messages[1], messages[2])
+
+	page, err = s.Page("237", CursorOf(messages[7]), After, 3)
+	if err != nil {
+		t.Fatal("unable to get page after cursor", err)
+	}
+	expectTexts(t, page, messages[8], messages[9])
+}
+
+func TestPageSameDate(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	s := NewStore(db)
+	messages := generateMessages("237", 6)
+	ids := make([]string, len(messages))
+	for i := range ids {
+		ids[i] = uuid.NewV4().String()
+	}
+	slices.Sort(ids)
+
+	for i := range messages {
+		messages[i].Date = messages[0].Date
+		messages[i].Id = ids[i]
+		if err := s.Save(messages[i]); err != nil {
+			t.Fatal("unable to save messages", err)
+		}
+	}
+
+	page, err := s.Page("237", CursorOf(messages[3]), Before, 10)
+	if err != nil {
+		t.Fatal("unable to get page before cursor", err)
+	}
+	expectTexts(t, page, messages[2], messages[1], messages[0])
+
+	page, err = s.Page("237", CursorOf(messages[3]), After, 10)
+	if err != nil {
+		t.Fatal("unable to get page after cursor", err)
+	}
+	expectTexts(t, page, messages[4], messages[5])
+}
+
+func TestCursor(t *testing.T) {
+	c := Cursor{Date: time.Now(), Id: uuid.NewV4().String()}
+	c2, err := ParseCursor(c.String())
+	if err != nil {
+		t.Fatal("unable to parse cursor", err)
+	}
+	if !c2.Date.Equal(c.Date) || c2.Id != c.Id {
+		t.Errorf("cursor did not round trip, expected %v, got %v", c, c2)
+	}
+
+	for _, bad := range []string{"", "!!", uuid.NewV4().String()} {
+		if _, err = ParseCursor(bad); !errors.Is(err, cicada.ErrorBadRequest) {
+			t.Errorf("expected bad request for %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	s := NewStore(db)
+	m := generateMessages("237", 1)[0]
+	if err := s.Save(m); err != nil {
+		t.Fatal("unable to save message", err)
+	}
+
+	m2, err := s.Get(m.Id)
+	if err != nil {
+		t.Fatal("unable to get message", err)
+	}
+	if m2.Text != m.Text {
+		t.Errorf("expected %s, got %s", m.Text, m2.Text)
+	}
+
+	if _, err = s.Get("asdf"); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected not found, got", err)
+	}
+}
+
+func expectTexts(t *testing.T, page []cicada.ChatMessage, expected ...cicada.ChatMessage) {
+	t.Helper()
+	if len(page) != len(expected) {
+		t.Fatalf("expected %d messages, got %d", len(expected), len(page))
+	}
+	for i := range expected {
+		if page[i].Text != expected[i].Text {
+			t.Errorf("message %d: expected %s, got %s", i, expected[i].Text, page[i].Text)
+		}
+	}
+}
+
+func TestImageIds(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	s := NewStore(db)
+	messages := generateMessages("images", 3)
+	messages[0].Images = []cicada.Image{{Id: "a"}, {Id: "b"}}
+	messages[2].Images = []cicada.Image{{Id: "a"}}
+	other := generateMessages("other", 1)[0]
+	other.Images = []cicada.Image{{Id: "c"}}
+
+	for _, m := range append(messages, other) {
+		if err := s.Save(m); err != nil {
+			t.Fatal("error saving message", err)
+		}
+	}
+
+	ids, err := s.ImageIds("images")
+	if err != nil {
+		t.Fatal("error listing image ids", err)
+	}
+	slices.Sort(ids)
+	if !slices.Equal(ids, []string{"a", "a", "b"}) {
+		t.Error("expected a reference per message, got", ids)
+	}
+
+	live, err := s.ReferencedImages()
+	if err != nil {
+		t.Fatal("error listing referenced images", err)
+	}
+	if len(live) != 3 || !live["a"] || !live["b"] || !live["c"] {
+		t.Error("expected images a, b and c to be referenced, got", live)
+	}
+}
+
+func TestMarkRead(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	s := NewStore(db)
+	messages := generateMessages("receipts", 3)
+	receiptOf := func(m cicada.ChatMessage) cicada.ReadReceipt {
+		return cicada.ReadReceipt{UserId: "reader", RoomId: m.RoomId, MessageId: m.Id, Date: m.Date}
+	}
+
+	if _, err := s.LastRead("reader", "receipts"); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Fatal("expected no receipt before marking the room read, got", err)
+	}
+
+	r, moved, err := s.MarkRead(receiptOf(messages[1]))
+	if err != nil {
+		t.Fatal("error marking room read", err)
+	}
+	if !moved || r.MessageId != messages[1].Id {
+		t.Error("expected the first receipt to be stored, got", r, moved)
+	}
+
+	r, moved, err = s.MarkRead(receiptOf(messages[0]))
+	if err != nil {
+		t.Fatal("error marking room read", err)
+	}
+	if moved || r.MessageId != messages[1].Id {
+		t.Error("expected the receipt not to move backwards, got", r, moved)
+	}
+
+	_, moved, err = s.MarkRead(receiptOf(messages[2]))
+	if err != nil {
+		t.Fatal("error marking room read", err)
+	}
+	if !moved {
+		t.Error("expected the receipt to move forwards")
+	}
+
+	r, err = s.LastRead("reader", "receipts")
+	if err != nil {
+		t.Fatal("error reading receipt", err)
+	}
+	if r.MessageId != messages[2].Id || !r.Date.Equal(messages[2].Date) {
+		t.Error("expected the newest receipt, got", r)
+	}
+
+	if err = s.DeleteReceipt("reader", "receipts"); err != nil {
+		t.Fatal("error deleting receipt", err)
+	}
+	if _, err = s.LastRead("reader", "receipts"); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected the receipt to be deleted, got", err)
+	}
+}
+
+func TestCountAfter(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	s := NewStore(db)
+	messages := generateMessages("unread", 5)
+	messages[4].Sender = "reader"
+	// messages in a room whose id extends this one are not counted
+	for _, m := range append(messages, generateMessages("unread0", 3)...) {
+		if err := s.Save(m); err != nil {
+			t.Fatal("error saving message", err)
+		}
+	}
+
+	count, err := s.CountAfter("unread", Cursor{}, "reader")
+	if err != nil {
+		t.Fatal("error counting messages", err)
+	}
+	if count != 4 {
+		t.Error("expected 4 messages from others, got", count)
+	}
+
+	count, err = s.CountAfter("unread", CursorOf(messages[1]), "reader")
+	if err != nil {
+		t.Fatal("error counting messages", err)
+	}
+	if count != 2 {
+		t.Error("expected 2 messages from others after the cursor, got", count)
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	s := NewStore(db)
+	m := generateMessages("revisions", 1)[0]
+	if err := s.Save(m); err != nil {
+		t.Fatal("error saving message", err)
+	}
+
+	texts := []string{m.Text, "second draft"}
+	for i, text := range texts {
+		r := cicada.Revision{MessageId: m.Id, RoomId: m.RoomId, Text: text, Date: m.Date.Add(time.Duration(i) * time.Second)}
+		if err := s.SaveRevision(r); err != nil {
+			t.Fatal("error saving revision", err)
+		}
+	}
+
+	editedAt := time.Now()
+	m.Text = "final"
+	m.EditedAt = &editedAt
+	if err := s.Replace(m); err != nil {
+		t.Fatal("error replacing message", err)
+	}
+
+	stored, err := s.Get(m.Id)
+	if err != nil {
+		t.Fatal("error getting message", err)
+	}
+	if stored.Text != "final" || stored.EditedAt == nil || !stored.EditedAt.Equal(editedAt) {
+		t.Error("replaced message did not round trip", stored)
+	}
+
+	history, err := s.Revisions(m.Id)
+	if err != nil {
+		t.Fatal("error listing revisions", err)
+	}
+	if len(history) != 2 || history[0].Text != texts[0] || history[1].Text != texts[1] {
+		t.Error("expected revisions oldest first, got", history)
+	}
+
+	if err = s.DeleteRevisions(m.Id); err != nil {
+		t.Fatal("error deleting revisions", err)
+	}
+	if history, _ = s.Revisions(m.Id); len(history) != 0 {
+		t.Error("expected revisions to be deleted, got", history)
+	}
+
+	if err = s.Replace(generateMessages("revisions", 1)[0]); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected not found replacing a missing message, got", err)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	s := NewStore(db)
+	m := generateMessages("reactions", 1)[0]
+	if err := s.Save(m); err != nil {
+		t.Fatal("error saving message", err)
+	}
+
+	for _, r := range []struct {
+		emoji, user  string
+		add, changed bool
+	}{
+		{"👍", "alice", true, true},
+		{"👍", "bob", true, true},
+		{"👍", "alice", true, false},
+		{"🎉", "alice", true, true},
+		{"🎉", "alice", false, true},
+		{"🎉", "bob", false, false},
+	} {
+		_, changed, err := s.React(m.Id, r.emoji, r.user, r.add)
+		if err != nil {
+			t.Fatal("error reacting to message", err)
+		}
+		if changed != r.changed {
+			t.Errorf("%s reacting with %s (add %v): expected changed %v", r.user, r.emoji, r.add, r.changed)
+		}
+	}
+
+	w, err := s.GetWindow("reactions", 0, 1)
+	if err != nil {
+		t.Fatal("error getting a chat window", err)
+	}
+	if len(w) != 1 {
+		t.Fatal("expected one chat message, got", len(w))
+	}
+	if len(w[0].ReactionCounts) != 1 || w[0].ReactionCounts["👍"] != 2 {
+		t.Error("expected two 👍 reactions, got", w[0].ReactionCounts)
+	}
+	if !slices.Equal(w[0].Reactions["👍"], []string{"alice", "bob"}) {
+		t.Error("expected alice and bob to have reacted, got", w[0].Reactions)
+	}
+
+	if _, _, err = s.React(uuid.NewV4().String(), "👍", "alice", true); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected not found reacting to a missing message, got", err)
+	}
+}
+
+func TestThreads(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	s := NewStore(db)
+	messages := generateMessages("threads", 5)
+	parent := messages[0]
+	for i := 1; i < 4; i++ {
+		messages[i].ParentId = parent.Id
+	}
+	for _, m := range messages {
+		if err := s.Save(m); err != nil {
+			t.Fatal("error saving message", err)
+		}
+	}
+
+	timeline, err := s.Page("threads", Cursor{}, After, 10)
+	if err != nil {
+		t.Fatal("error paging timeline", err)
+	}
+	if len(timeline) != 2 || timeline[0].Id != parent.Id || timeline[1].Id != messages[4].Id {
+		t.Error("expected replies to be left out of the timeline, got", timeline)
+	}
+
+	count, err := s.CountAfter("threads", Cursor{}, "")
+	if err != nil {
+		t.Fatal("error counting messages", err)
+	}
+	if count != 2 {
+		t.Error("expected replies to be left out of the count, got", count)
+	}
+
+	replies, err := s.Replies(parent.Id, Cursor{}, 2)
+	if err != nil {
+		t.Fatal("error reading replies", err)
+	}
+	if len(replies) != 2 || replies[0].Id != messages[1].Id || replies[1].Id != messages[2].Id {
+		t.Error("expected the first two replies, got", replies)
+	}
+
+	replies, err = s.Replies(parent.Id, CursorOf(replies[1]), 2)
+	if err != nil {
+		t.Fatal("error reading replies", err)
+	}
+	if len(replies) != 1 || replies[0].Id != messages[3].Id {
+		t.Error("expected the last reply, got", replies)
+	}
+
+	threads, err := s.Threads([]string{parent.Id, messages[4].Id})
+	if err != nil {
+		t.Fatal("error summarising threads", err)
+	}
+	if len(threads) != 1 {
+		t.Fatal("expected one thread, got", threads)
+	}
+	thread := threads[parent.Id]
+	if thread.Replies != 3 || !thread.LastReply.Equal(messages[3].Date) {
+		t.Error("expected three replies ending with the last, got", thread)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	s := NewStore(db)
+	messages := generateMessages("retention", 10)
+	for _, m := range append(messages, generateMessages("other", 3)...) {
+		if err := s.Save(m); err != nil {
+			t.Fatal("error saving message", err)
+		}
+	}
+
+	old, err := s.OlderThan("retention", messages[4].Date, 3)
+	if err != nil {
+		t.Fatal("error finding old messages", err)
+	}
+	expectTexts(t, old, messages[0], messages[1], messages[2])
+
+	old, err = s.OlderThan("retention", messages[2].Date, 3)
+	if err != nil {
+		t.Fatal("error finding old messages", err)
+	}
+	expectTexts(t, old, messages[0], messages[1])
+
+	beyond, err := s.BeyondNewest("retention", 7, 2)
+	if err != nil {
+		t.Fatal("error finding messages beyond the newest", err)
+	}
+	expectTexts(t, beyond, messages[2], messages[1])
+
+	if err = s.SaveRevision(cicada.Revision{MessageId: messages[0].Id, RoomId: "retention", Text: "draft", Date: messages[0].Date}); err != nil {
+		t.Fatal("error saving revision", err)
+	}
+	if err = s.Prune([]string{messages[0].Id, messages[1].Id}); err != nil {
+		t.Fatal("error pruning messages", err)
+	}
+	if _, err = s.Get(messages[0].Id); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected pruned message to be gone, got", err)
+	}
+	if history, _ := s.Revisions(messages[0].Id); len(history) != 0 {
+		t.Error("expected revisions of pruned messages to be gone, got", history)
+	}
+
+	beyond, err = s.BeyondNewest("retention", 7, 10)
+	if err != nil {
+		t.Fatal("error finding messages beyond the newest", err)
+	}
+	expectTexts(t, beyond, messages[2])
+
+	if beyond, _ = s.BeyondNewest("other", 3, 10); len(beyond) != 0 {
+		t.Error("expected no messages beyond the newest of another room, got", beyond)
+	}
+}
+
+func TestSince(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	s := NewStore(db)
+	first := generateMessages("since-a", 4)
+	second := generateMessages("since-b", 4)
+	other := generateMessages("since-c", 4)
+	now := time.Now()
+	for i := range first {
+		// interleave the rooms, and share a date between two of them
+		first[i].Date = now.Add(time.Duration(i) * time.Second)
+		second[i].Date = first[i].Date.Add(time.Millisecond)
+		second[i].Text = "second" + strconv.Itoa(i)
+		other[i].Date = first[i].Date
+	}
+	first[2].ParentId = first[1].Id
+	for _, m := range slices.Concat(first, second, other) {
+		if err := s.Save(m); err != nil {
+			t.Fatal("error saving message", err)
+		}
+	}
+
+	rooms := []string{"since-a", "since-b"}
+	missed, err := s.Since(rooms, CursorOf(first[1]), 10)
+	if err != nil {
+		t.Fatal("error reading messages since cursor", err)
+	}
+	expectTexts(t, missed, second[1], first[2], second[2], first[3], second[3])
+
+	missed, err = s.Since(rooms, CursorOf(first[1]), 2)
+	if err != nil {
+		t.Fatal("error reading messages since cursor", err)
+	}
+	expectTexts(t, missed, second[1], first[2])
+
+	missed, err = s.Since(rooms, CursorOf(second[3]), 10)
+	if err != nil {
+		t.Fatal("error reading messages since cursor", err)
+	}
+	if len(missed) != 0 {
+		t.Error("expected nothing after the newest message, got", missed)
+	}
+
+	if missed, err = s.Since(nil, Cursor{}, 10); err != nil || len(missed) != 0 {
+		t.Error("expected nothing without rooms, got", missed, err)
+	}
+}
+
+func TestSinceBackfill(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	NewStore(db)
+	// a message stored before the roomDate key existed
+	old := generateMessages("since-old", 1)[0]
+	if err := db.Insert(collection, document.NewDocumentOf(old)); err != nil {
+		t.Fatal("error saving message", err)
+	}
+
+	s := NewStore(db)
+	missed, err := s.Since([]string{"since-old"}, Cursor{}, 10)
+	if err != nil {
+		t.Fatal("error reading messages since cursor", err)
+	}
+	expectTexts(t, missed, old)
+}
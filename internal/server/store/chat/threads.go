@@ -0,0 +1,84 @@
+package chat
+
+import (
+	"cicada"
+	"github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/query"
+)
+
+// Replies fetches up to size replies to a message after a cursor, oldest
+// first. A zero cursor starts from the first reply.
+func (s *Store) Replies(parentId string, c Cursor, size int) ([]cicada.ChatMessage, error) {
+	if size <= 0 {
+		return nil, cicada.ErrorBadRequest
+	}
+
+	// a single criteria on parentId lets clover use its index. Replies are
+	// then sorted by date in memory, and the sort does not stop early when
+	// the callback returns false, so a full page or a failure is checked
+	// before decoding.
+	q := query.NewQuery(collection).
+		Where(query.Field("parentId").Eq(parentId)).
+		Sort(query.SortOption{Field: "date", Direction: 1})
+
+	replies := make([]cicada.ChatMessage, 0, size)
+	var err error
+	e := s.db.ForEach(q, func(doc *document.Document) bool {
+		if err != nil || len(replies) == size {
+			return false
+		}
+
+		m := cicada.ChatMessage{}
+		if err = doc.Unmarshal(&m); err != nil {
+			return false
+		}
+
+		if c.IsZero() || c.after(m) {
+			replies = append(replies, m)
+		}
+		return len(replies) < size
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	if e = processError(e); e != nil {
+		return nil, e
+	}
+	return replies, nil
+}
+
+// Threads counts the replies to each of the given messages, and when the
+// last one was sent. Messages without replies are left out of the result.
+func (s *Store) Threads(parentIds []string) (map[string]cicada.Thread, error) {
+	threads := make(map[string]cicada.Thread)
+	for _, parentId := range parentIds {
+		t := cicada.Thread{ParentId: parentId}
+		var err error
+		q := query.NewQuery(collection).
+			Where(query.Field("parentId").Eq(parentId))
+		e := s.db.ForEach(q, func(doc *document.Document) bool {
+			m := cicada.ChatMessage{}
+			if err = doc.Unmarshal(&m); err != nil {
+				return false
+			}
+
+			t.Replies++
+			if m.Date.After(t.LastReply) {
+				t.LastReply = m.Date
+			}
+			return true
+		})
+
+		if err != nil {
+			return nil, err
+		}
+		if e = processError(e); e != nil {
+			return nil, e
+		}
+		if t.Replies > 0 {
+			threads[parentId] = t
+		}
+	}
+	return threads, nil
+}
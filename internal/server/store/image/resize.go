@@ -0,0 +1,164 @@
+package image
+
+import (
+	"bytes"
+	"cicada"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+const (
+	// maxPixels bounds the size of images that are decoded to make renditions,
+	// so a small file cannot expand into an enormous bitmap.
+	maxPixels = 16_000_000
+	// jpegQuality is used when encoding renditions of JPEG images.
+	jpegQuality = 80
+)
+
+// renditionSizes gives the longest side, in pixels, of each rendition.
+var renditionSizes = []struct {
+	name  string
+	limit int
+}{
+	{cicada.RenditionMedium, 800},
+	{cicada.RenditionThumb, 160},
+}
+
+// decoding holds a token while an image is decoded and scaled, so concurrent
+// uploads cannot each hold a full size bitmap at once.
+var decoding = make(chan struct{}, 1)
+
+// rendition is an encoded scaled down copy of an image.
+type rendition struct {
+	cicada.Rendition
+	bytes []byte
+}
+
+// dimensions reads the width and height of an image without decoding it.
+func dimensions(b []byte) (int, int, bool) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return 0, 0, false
+	}
+	return config.Width, config.Height, true
+}
+
+// makeRenditions decodes an image and encodes a copy for every rendition size
+// smaller than the image. Images in formats the standard library cannot
+// decode, or that are too large to decode safely, get no renditions.
+func makeRenditions(b []byte, contentType string) (map[string]rendition, error) {
+	renditions := make(map[string]rendition)
+
+	width, height, ok := dimensions(b)
+	if !ok || width*height > maxPixels {
+		return renditions, nil
+	}
+
+	decoding <- struct{}{}
+	defer func() { <-decoding }()
+
+	src, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return renditions, nil
+	}
+
+	// each rendition is scaled from the previous one, largest first
+	current := toRGBA(src)
+	for _, size := range renditionSizes {
+		w, h := fit(current.Bounds().Dx(), current.Bounds().Dy(), size.limit)
+		if w == current.Bounds().Dx() && h == current.Bounds().Dy() {
+			continue
+		}
+		current = shrink(current, w, h)
+
+		r, err := encode(current, contentType)
+		if err != nil {
+			return nil, err
+		}
+		renditions[size.name] = r
+	}
+
+	return renditions, nil
+}
+
+// fit scales width and height down so that neither is larger than limit,
+// keeping the aspect ratio.
+func fit(width, height, limit int) (int, int) {
+	if width <= limit && height <= limit {
+		return width, height
+	}
+	if width >= height {
+		return limit, max(1, height*limit/width)
+	}
+	return max(1, width*limit/height), limit
+}
+
+func toRGBA(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	return dst
+}
+
+// shrink scales src down to width by height, averaging the source pixels
+// that fall in each destination pixel.
+func shrink(src *image.RGBA, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, max((y+1)*sh/height, y*sh/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, max((x+1)*sw/width, x*sw/width+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(src.Pix[i])
+					g += uint64(src.Pix[i+1])
+					b += uint64(src.Pix[i+2])
+					a += uint64(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(b / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// encode writes a rendition as a JPEG when the original was one, and as a PNG
+// otherwise so transparency is kept.
+func encode(img *image.RGBA, contentType string) (rendition, error) {
+	buf := &bytes.Buffer{}
+	var err error
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		contentType = "image/png"
+		err = png.Encode(buf, img)
+	}
+	if err != nil {
+		return rendition{}, err
+	}
+
+	return rendition{
+		Rendition: cicada.Rendition{
+			ContentType: contentType,
+			Size:        int64(buf.Len()),
+			Width:       img.Bounds().Dx(),
+			Height:      img.Bounds().Dy(),
+		},
+		bytes: buf.Bytes(),
+	}, nil
+}
@@ -4,10 +4,40 @@ import (
 	"cicada"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	badger "github.com/dgraph-io/badger/v4"
+	"net/http"
+	"slices"
+	"time"
 )
 
+const (
+	metaPrefix = "meta:"
+	refsPrefix = "refs:"
+	// batchSize bounds the number of images changed in one transaction.
+	batchSize = 256
+)
+
+// ContentTypes lists the image formats the store accepts.
+var ContentTypes = []string{"image/gif", "image/jpeg", "image/png", "image/webp"}
+
+// Report summarises a garbage collection pass.
+type Report struct {
+	Scanned        int   `json:"scanned"`
+	Removed        int   `json:"removed"`
+	BytesReclaimed int64 `json:"bytesReclaimed"`
+}
+
+// refs counts the messages that point to a blob. Updated records the last
+// time the count changed or the blob was uploaded, so that fresh uploads are
+// not collected before a message has had a chance to reference them.
+type refs struct {
+	Count   int64     `json:"count"`
+	Updated time.Time `json:"updated"`
+}
+
 type Store struct {
 	db *badger.DB
 }
@@ -17,6 +47,10 @@ func NewStore(db *badger.DB) *Store {
 }
 
 func (r *Store) Get(id string) ([]byte, error) {
+	if !validId(id) {
+		return nil, cicada.ErrorNotFound
+	}
+
 	var buffer []byte
 
 	err := r.db.View(func(txn *badger.Txn) error {
@@ -36,22 +70,99 @@ func (r *Store) Get(id string) ([]byte, error) {
 	return buffer, nil
 }
 
+// View calls fn with an image's metadata and contents. The contents are only
+// valid until fn returns, which avoids copying large blobs out of the database.
+// Images stored without metadata have their content type detected.
+//
+// A size other than "" selects a scaled down copy of the image. The
+// metadata passed to fn then describes the copy. Images without the rendition
+// are served in full, since they were already small enough.
+func (r *Store) View(id, size string, fn func(img cicada.Image, b []byte) error) error {
+	if !validId(id) {
+		return cicada.ErrorNotFound
+	}
+
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(id))
+		if err != nil {
+			return err
+		}
+
+		img := cicada.Image{Id: id, Size: item.ValueSize()}
+		meta, err := txn.Get(metaKey(id))
+		if err == nil {
+			err = meta.Value(func(val []byte) error {
+				return json.Unmarshal(val, &img)
+			})
+		} else if errors.Is(err, badger.ErrKeyNotFound) {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if rend, ok := img.Renditions[size]; ok {
+			item, err = txn.Get(renditionKey(id, size))
+			if err != nil {
+				return err
+			}
+
+			img.ContentType = rend.ContentType
+			img.Size = rend.Size
+			img.Width = rend.Width
+			img.Height = rend.Height
+			img.Renditions = nil
+		}
+
+		return item.Value(func(val []byte) error {
+			if len(img.ContentType) == 0 {
+				img.ContentType = http.DetectContentType(val)
+			}
+			return fn(img, val)
+		})
+	})
+	return processError(err)
+}
+
+// Metadata returns the stored description of an image.
+func (r *Store) Metadata(id string) (cicada.Image, error) {
+	img := cicada.Image{}
+	if !validId(id) {
+		return img, cicada.ErrorNotFound
+	}
+
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(metaKey(id))
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &img)
+		})
+	})
+	return img, processError(err)
+}
+
 func (r *Store) Delete(id string) error {
+	if !validId(id) {
+		return cicada.ErrorNotFound
+	}
+
 	err := r.db.Update(func(txn *badger.Txn) error {
 		key := []byte(id)
 		_, err := txn.Get(key)
 		if err != nil {
 			return err
 		}
-		return txn.Delete(key)
+
+		return deleteImage(txn, id)
 	})
 	return processError(err)
 }
 
 func (r *Store) Put(bytes []byte) (string, error) {
-	hasher := sha256.New()
-	hasher.Write(bytes)
-	id := hex.EncodeToString(hasher.Sum(nil))
+	id := hash(bytes)
 	err := r.db.Update(func(txn *badger.Txn) error {
 		return txn.Set([]byte(id), bytes)
 	})
@@ -62,6 +173,319 @@ func (r *Store) Put(bytes []byte) (string, error) {
 	return id, nil
 }
 
+// Save stores an uploaded image along with its metadata. The content type is
+// detected from the bytes and must be one of ContentTypes. Scaled down
+// renditions are stored alongside the original when it can be decoded.
+func (r *Store) Save(name string, bytes []byte) (cicada.Image, error) {
+	contentType, err := DetectContentType(bytes)
+	if err != nil {
+		return cicada.Image{}, err
+	}
+
+	img := cicada.Image{
+		Id:          hash(bytes),
+		Name:        name,
+		ContentType: contentType,
+		Size:        int64(len(bytes)),
+	}
+	img.Width, img.Height, _ = dimensions(bytes)
+
+	renditions, err := makeRenditions(bytes, contentType)
+	if err != nil {
+		return cicada.Image{}, err
+	}
+	if len(renditions) > 0 {
+		img.Renditions = make(map[string]cicada.Rendition, len(renditions))
+		for size, rend := range renditions {
+			img.Renditions[size] = rend.Rendition
+		}
+	}
+
+	meta, err := json.Marshal(img)
+	if err != nil {
+		return cicada.Image{}, err
+	}
+
+	err = r.db.Update(func(txn *badger.Txn) error {
+		err := txn.Set([]byte(img.Id), bytes)
+		if err != nil {
+			return err
+		}
+
+		err = txn.Set(metaKey(img.Id), meta)
+		if err != nil {
+			return err
+		}
+
+		for size, rend := range renditions {
+			err = txn.Set(renditionKey(img.Id, size), rend.bytes)
+			if err != nil {
+				return err
+			}
+		}
+
+		// uploading the same bytes again restarts the grace period but
+		// keeps the references held by existing messages
+		rc, err := getRefs(txn, img.Id)
+		if err != nil {
+			return err
+		}
+		rc.Updated = time.Now()
+		return setRefs(txn, img.Id, rc)
+	})
+
+	if err != nil {
+		return cicada.Image{}, err
+	}
+	return img, nil
+}
+
+// Acquire adds a reference to each image. An id may be given more than once
+// to add several references. No references are added if any image is missing.
+func (r *Store) Acquire(ids ...string) error {
+	for _, id := range ids {
+		if !validId(id) {
+			return fmt.Errorf("%w: image %s", cicada.ErrorNotFound, id)
+		}
+	}
+
+	err := r.db.Update(func(txn *badger.Txn) error {
+		for _, id := range ids {
+			_, err := txn.Get([]byte(id))
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return fmt.Errorf("%w: image %s", cicada.ErrorNotFound, id)
+			} else if err != nil {
+				return err
+			}
+
+			rc, err := getRefs(txn, id)
+			if err != nil {
+				return err
+			}
+			rc.Count++
+			rc.Updated = time.Now()
+			if err = setRefs(txn, id, rc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return processError(err)
+}
+
+// Release drops a reference to each image. Images are never deleted here,
+// even when their last reference goes; Collect reclaims them once the grace
+// period has passed, so an upload about to be referenced again is not lost.
+// Unknown ids are ignored.
+func (r *Store) Release(ids ...string) error {
+	for len(ids) > 0 {
+		n := min(len(ids), batchSize)
+		err := r.db.Update(func(txn *badger.Txn) error {
+			for _, id := range ids[:n] {
+				if !validId(id) {
+					continue
+				}
+
+				_, err := txn.Get([]byte(id))
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					continue
+				} else if err != nil {
+					return err
+				}
+
+				rc, err := getRefs(txn, id)
+				if err != nil {
+					return err
+				}
+				rc.Count = max(rc.Count-1, 0)
+				rc.Updated = time.Now()
+				if err = setRefs(txn, id, rc); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		if err != nil {
+			return processError(err)
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+// Collect deletes every image that is not in live, has no references left
+// and has not been uploaded or referenced within the grace period. An image
+// still counted as referenced is kept even when live misses it, so a message
+// saved while the live set was being built does not lose its images.
+func (r *Store) Collect(live map[string]bool, grace time.Duration) (Report, error) {
+	report := Report{}
+	cutoff := time.Now().Add(-grace)
+
+	var candidates []string
+	err := r.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			id := string(it.Item().Key())
+			if !validId(id) {
+				continue
+			}
+
+			report.Scanned++
+			if !live[id] {
+				candidates = append(candidates, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for len(candidates) > 0 {
+		n := min(len(candidates), batchSize)
+		removed, reclaimed := 0, int64(0)
+		err = r.db.Update(func(txn *badger.Txn) error {
+			removed, reclaimed = 0, 0
+			for _, id := range candidates[:n] {
+				// check again inside the transaction, a message may have
+				// referenced the image since the live set was built
+				rc, err := getRefs(txn, id)
+				if err != nil {
+					return err
+				}
+				if rc.Count > 0 || rc.Updated.After(cutoff) {
+					continue
+				}
+
+				size, err := storedSize(txn, id)
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					continue
+				} else if err != nil {
+					return err
+				}
+
+				if err = deleteImage(txn, id); err != nil {
+					return err
+				}
+				removed++
+				reclaimed += size
+			}
+			return nil
+		})
+
+		if err != nil {
+			return report, err
+		}
+		report.Removed += removed
+		report.BytesReclaimed += reclaimed
+		candidates = candidates[n:]
+	}
+
+	return report, nil
+}
+
+// DetectContentType sniffs the format of an image from its leading bytes.
+func DetectContentType(bytes []byte) (string, error) {
+	contentType := http.DetectContentType(bytes)
+	if !slices.Contains(ContentTypes, contentType) {
+		return "", fmt.Errorf("%w: %s", cicada.ErrorUnsupportedMedia, contentType)
+	}
+	return contentType, nil
+}
+
+func hash(bytes []byte) string {
+	hasher := sha256.New()
+	hasher.Write(bytes)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// validId reports whether id could be a hex encoded SHA-256 hash. Keeping
+// other keys out prevents reads of metadata or other records in the database.
+func validId(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func metaKey(id string) []byte {
+	return []byte(metaPrefix + id)
+}
+
+func renditionKey(id, size string) []byte {
+	return []byte(id + ":" + size)
+}
+
+func refsKey(id string) []byte {
+	return []byte(refsPrefix + id)
+}
+
+// getRefs reads the reference count of an image. Images stored before counts
+// were kept have no record, and are treated as unreferenced.
+func getRefs(txn *badger.Txn, id string) (refs, error) {
+	rc := refs{}
+	item, err := txn.Get(refsKey(id))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return rc, nil
+	} else if err != nil {
+		return rc, err
+	}
+
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &rc)
+	})
+	return rc, err
+}
+
+func setRefs(txn *badger.Txn, id string, rc refs) error {
+	b, err := json.Marshal(rc)
+	if err != nil {
+		return err
+	}
+	return txn.Set(refsKey(id), b)
+}
+
+// deleteImage removes a blob along with its renditions, metadata and
+// reference count.
+func deleteImage(txn *badger.Txn, id string) error {
+	keys := [][]byte{metaKey(id), refsKey(id)}
+	for _, size := range renditionSizes {
+		keys = append(keys, renditionKey(id, size.name))
+	}
+
+	for _, key := range keys {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return txn.Delete([]byte(id))
+}
+
+// storedSize adds up the bytes used by a blob and its renditions.
+func storedSize(txn *badger.Txn, id string) (int64, error) {
+	item, err := txn.Get([]byte(id))
+	if err != nil {
+		return 0, err
+	}
+	size := item.ValueSize()
+
+	for _, rend := range renditionSizes {
+		item, err = txn.Get(renditionKey(id, rend.name))
+		if err == nil {
+			size += item.ValueSize()
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
 func processError(e error) error {
 	if e == nil {
 		return e
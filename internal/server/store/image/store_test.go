@@ -6,14 +6,25 @@ import (
 	"crypto/rand"
 	"errors"
 	"github.com/dgraph-io/badger/v4"
+	stdimage "image"
+	"image/jpeg"
+	"image/png"
 	"log"
 	"log/slog"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
+// database opens badger in a fresh temporary directory. Callers must close the
+// database before removing the directory, or badger blocks flushing to it.
 func database() (*badger.DB, string) {
-	dbDir := os.TempDir()
+	dbDir, err := os.MkdirTemp("", "cicada-image")
+	if err != nil {
+		log.Fatal("unable to create temp dir", err)
+	}
 	slog.Info("using temp dir", "dir", dbDir)
 	db, err := badger.Open(badger.DefaultOptions(dbDir))
 	if err != nil {
@@ -24,8 +35,8 @@ func database() (*badger.DB, string) {
 
 func TestRoundTrip(t *testing.T) {
 	db, dbDir := database()
-	defer db.Close()
 	defer os.RemoveAll(dbDir)
+	defer db.Close()
 
 	image := make([]byte, 1024)
 	_, err := rand.Read(image)
@@ -58,8 +69,8 @@ func TestRoundTrip(t *testing.T) {
 
 func TestDelete(t *testing.T) {
 	db, dbDir := database()
-	defer db.Close()
 	defer os.RemoveAll(dbDir)
+	defer db.Close()
 
 	image := make([]byte, 1024)
 	_, err := rand.Read(image)
@@ -85,8 +96,8 @@ func TestDelete(t *testing.T) {
 
 func TestBadDelete(t *testing.T) {
 	db, dbDir := database()
-	defer db.Close()
 	defer os.RemoveAll(dbDir)
+	defer db.Close()
 
 	store := NewStore(db)
 	err := store.Delete("asdf")
@@ -100,8 +111,8 @@ func TestBadDelete(t *testing.T) {
 
 func TestBadGet(t *testing.T) {
 	db, dbDir := database()
-	defer db.Close()
 	defer os.RemoveAll(dbDir)
+	defer db.Close()
 
 	store := NewStore(db)
 	_, err := store.Get("asdf")
@@ -113,3 +124,310 @@ func TestBadGet(t *testing.T) {
 		t.Error("expected not found, got", err)
 	}
 }
+
+func TestSave(t *testing.T) {
+	db, dbDir := database()
+	defer os.RemoveAll(dbDir)
+	defer db.Close()
+
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), make([]byte, 100)...)
+
+	store := NewStore(db)
+	img, err := store.Save("pixel.png", png)
+	if err != nil {
+		t.Fatal("error saving image", err)
+	}
+
+	if img.ContentType != "image/png" {
+		t.Errorf("expected image/png, got %s", img.ContentType)
+	}
+	if img.Size != int64(len(png)) {
+		t.Errorf("expected size %d, got %d", len(png), img.Size)
+	}
+
+	meta, err := store.Metadata(img.Id)
+	if err != nil {
+		t.Fatal("error reading metadata", err)
+	}
+	if !reflect.DeepEqual(meta, img) {
+		t.Errorf("metadata did not round trip, expected %v, got %v", img, meta)
+	}
+
+	b, err := store.Get(img.Id)
+	if err != nil {
+		t.Fatal("error reading image", err)
+	}
+	if bytes.Compare(b, png) != 0 {
+		t.Error("image and saved image are not the same")
+	}
+
+	if err = store.Delete(img.Id); err != nil {
+		t.Fatal("error deleting image", err)
+	}
+	if _, err = store.Metadata(img.Id); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected metadata to be deleted, got", err)
+	}
+}
+
+func TestSaveUnsupported(t *testing.T) {
+	db, dbDir := database()
+	defer os.RemoveAll(dbDir)
+	defer db.Close()
+
+	store := NewStore(db)
+	_, err := store.Save("notes.txt", []byte("just some text"))
+	if !errors.Is(err, cicada.ErrorUnsupportedMedia) {
+		t.Error("expected unsupported media, got", err)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	db, dbDir := database()
+	defer os.RemoveAll(dbDir)
+	defer db.Close()
+
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), make([]byte, 100)...)
+
+	store := NewStore(db)
+	img, err := store.Save("pixel.png", png)
+	if err != nil {
+		t.Fatal("error saving image", err)
+	}
+
+	err = store.Acquire(img.Id, img.Id)
+	if err != nil {
+		t.Fatal("error acquiring image", err)
+	}
+
+	err = store.Release(img.Id)
+	if err != nil {
+		t.Fatal("error releasing image", err)
+	}
+	if _, err = store.Get(img.Id); err != nil {
+		t.Fatal("image deleted while still referenced", err)
+	}
+
+	err = store.Release(img.Id)
+	if err != nil {
+		t.Fatal("error releasing image", err)
+	}
+	if _, err = store.Get(img.Id); err != nil {
+		t.Fatal("image deleted by its last release instead of collection", err)
+	}
+
+	report, err := store.Collect(nil, time.Hour)
+	if err != nil {
+		t.Fatal("error collecting images", err)
+	}
+	if report.Removed != 0 {
+		t.Error("expected a just released image to be kept during the grace period, got", report)
+	}
+
+	if _, err = store.Collect(nil, 0); err != nil {
+		t.Fatal("error collecting images", err)
+	}
+	if _, err = store.Get(img.Id); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected unreferenced image to be collected, got", err)
+	}
+	if _, err = store.Metadata(img.Id); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected metadata to be collected, got", err)
+	}
+
+	if err = store.Acquire(img.Id); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected not found acquiring a deleted image, got", err)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	db, dbDir := database()
+	defer os.RemoveAll(dbDir)
+	defer db.Close()
+
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), make([]byte, 100)...)
+	gif := append([]byte("GIF89a"), make([]byte, 200)...)
+
+	store := NewStore(db)
+	kept, err := store.Save("kept.png", png)
+	if err != nil {
+		t.Fatal("error saving image", err)
+	}
+	orphan, err := store.Save("orphan.gif", gif)
+	if err != nil {
+		t.Fatal("error saving image", err)
+	}
+	held, err := store.Save("held.png", append(png, 1))
+	if err != nil {
+		t.Fatal("error saving image", err)
+	}
+	if err = store.Acquire(held.Id); err != nil {
+		t.Fatal("error acquiring image", err)
+	}
+
+	report, err := store.Collect(map[string]bool{kept.Id: true}, time.Hour)
+	if err != nil {
+		t.Fatal("error collecting images", err)
+	}
+	if report.Scanned != 3 || report.Removed != 0 {
+		t.Error("expected new uploads to be kept during the grace period, got", report)
+	}
+
+	report, err = store.Collect(map[string]bool{kept.Id: true}, 0)
+	if err != nil {
+		t.Fatal("error collecting images", err)
+	}
+	if report.Removed != 1 || report.BytesReclaimed != orphan.Size {
+		t.Errorf("expected to remove 1 image of %d bytes, got %+v", orphan.Size, report)
+	}
+
+	if _, err = store.Get(kept.Id); err != nil {
+		t.Error("referenced image was collected", err)
+	}
+	if _, err = store.Get(orphan.Id); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected orphaned image to be collected, got", err)
+	}
+	if _, err = store.Get(held.Id); err != nil {
+		t.Error("image with a reference was collected", err)
+	}
+}
+
+func TestView(t *testing.T) {
+	db, dbDir := database()
+	defer os.RemoveAll(dbDir)
+	defer db.Close()
+
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), make([]byte, 100)...)
+
+	store := NewStore(db)
+	saved, err := store.Save("pixel.png", png)
+	if err != nil {
+		t.Fatal("error saving image", err)
+	}
+
+	err = store.View(saved.Id, "", func(img cicada.Image, b []byte) error {
+		if !reflect.DeepEqual(img, saved) {
+			t.Errorf("expected metadata %+v, got %+v", saved, img)
+		}
+		if !bytes.Equal(b, png) {
+			t.Error("viewed bytes differ from the saved image")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("error viewing image", err)
+	}
+
+	// images written with Put have no metadata
+	id, err := store.Put(png)
+	if err != nil {
+		t.Fatal("error writing image to store", err)
+	}
+	err = store.View(id, "", func(img cicada.Image, b []byte) error {
+		if img.ContentType != "image/png" || img.Size != int64(len(png)) {
+			t.Errorf("expected detected metadata, got %+v", img)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("error viewing image", err)
+	}
+
+	err = store.View(strings.Repeat("0", 64), "", func(cicada.Image, []byte) error { return nil })
+	if !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected not found, got", err)
+	}
+}
+
+func encodedImage(t *testing.T, width, height int, format string) []byte {
+	img := stdimage.NewRGBA(stdimage.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+
+	buf := &bytes.Buffer{}
+	var err error
+	if format == "jpeg" {
+		err = jpeg.Encode(buf, img, nil)
+	} else {
+		err = png.Encode(buf, img)
+	}
+	if err != nil {
+		t.Fatal("error encoding image", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRenditions(t *testing.T) {
+	db, dbDir := database()
+	defer os.RemoveAll(dbDir)
+	defer db.Close()
+
+	store := NewStore(db)
+	img, err := store.Save("wide.jpg", encodedImage(t, 1000, 500, "jpeg"))
+	if err != nil {
+		t.Fatal("error saving image", err)
+	}
+
+	if img.Width != 1000 || img.Height != 500 {
+		t.Errorf("expected 1000x500, got %dx%d", img.Width, img.Height)
+	}
+
+	expected := map[string][2]int{
+		cicada.RenditionMedium: {800, 400},
+		cicada.RenditionThumb:  {160, 80},
+	}
+	for size, dims := range expected {
+		rend, ok := img.Renditions[size]
+		if !ok {
+			t.Fatal("missing rendition", size)
+		}
+		if rend.Width != dims[0] || rend.Height != dims[1] || rend.ContentType != "image/jpeg" {
+			t.Errorf("unexpected %s rendition %+v", size, rend)
+		}
+
+		err = store.View(img.Id, size, func(viewed cicada.Image, b []byte) error {
+			config, format, err := stdimage.DecodeConfig(bytes.NewReader(b))
+			if err != nil {
+				return err
+			}
+			if format != "jpeg" || config.Width != dims[0] || viewed.Size != int64(len(b)) {
+				t.Errorf("unexpected %s rendition served: %s %dx%d", size, format, config.Width, config.Height)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal("error viewing rendition", err)
+		}
+	}
+
+	// small images are only stored once, and served in full for any size
+	small, err := store.Save("small.png", encodedImage(t, 100, 300, "png"))
+	if err != nil {
+		t.Fatal("error saving image", err)
+	}
+	if _, ok := small.Renditions[cicada.RenditionThumb]; !ok {
+		t.Error("expected a thumbnail for an image taller than the thumbnail size")
+	}
+	if _, ok := small.Renditions[cicada.RenditionMedium]; ok {
+		t.Error("expected no medium rendition for an image smaller than it")
+	}
+	err = store.View(small.Id, cicada.RenditionMedium, func(viewed cicada.Image, b []byte) error {
+		if viewed.Size != small.Size || viewed.Width != 100 {
+			t.Errorf("expected the original image, got %+v", viewed)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("error viewing image", err)
+	}
+
+	if err = store.Delete(img.Id); err != nil {
+		t.Fatal("error deleting image", err)
+	}
+	err = db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(renditionKey(img.Id, cicada.RenditionThumb))
+		return err
+	})
+	if !errors.Is(err, badger.ErrKeyNotFound) {
+		t.Error("expected renditions to be deleted with the image, got", err)
+	}
+}
@@ -0,0 +1,56 @@
+package room
+
+import (
+	"cicada"
+	"github.com/ostafen/clover/v2"
+	"github.com/ostafen/clover/v2/document"
+	uuid "github.com/satori/go.uuid"
+	"log"
+)
+
+const (
+	invites = "invites"
+)
+
+func createInvites(db *clover.DB) {
+	exists, err := db.HasCollection(invites)
+	if err != nil {
+		log.Fatal("failed to create collection", invites, err)
+	}
+
+	if !exists {
+		err := db.CreateCollection(invites)
+		if err != nil {
+			log.Fatal("failed to create collection", invites, err)
+		}
+		err = db.CreateIndex(invites, "roomId")
+		if err != nil {
+			log.Fatal("failed to create roomId index for collection:", invites, err)
+		}
+	}
+}
+
+// PutInvite stores an invite. Its token must be a new uuid.
+func (s *Store) PutInvite(inv cicada.Invite) error {
+	return s.db.Insert(invites, document.NewDocumentOf(inv))
+}
+
+// GetInvite fetches an invite by its token.
+func (s *Store) GetInvite(token string) (cicada.Invite, error) {
+	inv := cicada.Invite{}
+	if _, err := uuid.FromString(token); err != nil {
+		return inv, cicada.ErrorNotFound
+	}
+
+	doc, err := s.db.FindById(invites, token)
+	if e := processError(err); e != nil {
+		return inv, e
+	}
+
+	if doc == nil {
+		return inv, cicada.ErrorNotFound
+	}
+
+	err = doc.Unmarshal(&inv)
+	return inv, err
+}
@@ -0,0 +1,85 @@
+package room
+
+import (
+	"cicada"
+	"github.com/ostafen/clover/v2"
+	"github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/query"
+	uuid "github.com/satori/go.uuid"
+	"log"
+)
+
+const (
+	sanctions = "sanctions"
+)
+
+func createSanctions(db *clover.DB) {
+	exists, err := db.HasCollection(sanctions)
+	if err != nil {
+		log.Fatal("failed to create collection", sanctions, err)
+	}
+
+	if !exists {
+		err := db.CreateCollection(sanctions)
+		if err != nil {
+			log.Fatal("failed to create collection", sanctions, err)
+		}
+		err = db.CreateIndex(sanctions, "roomId")
+		if err != nil {
+			log.Fatal("failed to create roomId index for collection:", sanctions, err)
+		}
+	}
+}
+
+// PutSanction stores a new sanction. Its id must be a new uuid.
+func (s *Store) PutSanction(sa cicada.Sanction) error {
+	return s.db.Insert(sanctions, document.NewDocumentOf(sa))
+}
+
+// ReplaceSanction overwrites a stored sanction, such as when it is revoked.
+func (s *Store) ReplaceSanction(sa cicada.Sanction) error {
+	return processError(s.db.ReplaceById(sanctions, sa.Id, document.NewDocumentOf(sa)))
+}
+
+// GetSanction fetches a sanction by its id.
+func (s *Store) GetSanction(id string) (cicada.Sanction, error) {
+	sa := cicada.Sanction{}
+	if _, err := uuid.FromString(id); err != nil {
+		return sa, cicada.ErrorNotFound
+	}
+
+	doc, err := s.db.FindById(sanctions, id)
+	if e := processError(err); e != nil {
+		return sa, e
+	}
+
+	if doc == nil {
+		return sa, cicada.ErrorNotFound
+	}
+
+	err = doc.Unmarshal(&sa)
+	return sa, err
+}
+
+// Sanctions lists the sanctions taken in a room, oldest first. If a user id
+// is given, only the sanctions against that user are listed.
+func (s *Store) Sanctions(roomId, userId string) ([]cicada.Sanction, error) {
+	criteria := query.Field("roomId").Eq(roomId)
+	if len(userId) != 0 {
+		criteria = criteria.And(query.Field("userId").Eq(userId))
+	}
+
+	q := query.NewQuery(sanctions).Where(criteria).Sort(query.SortOption{Field: "date", Direction: 1})
+	docs, err := s.db.FindAll(q)
+	if e := processError(err); e != nil {
+		return nil, e
+	}
+
+	list := make([]cicada.Sanction, len(docs))
+	for i, d := range docs {
+		if err = d.Unmarshal(&list[i]); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
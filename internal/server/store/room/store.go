@@ -3,11 +3,13 @@ package room
 import (
 	"cicada"
 	"errors"
+	"fmt"
 	"github.com/ostafen/clover/v2"
 	"github.com/ostafen/clover/v2/document"
 	"github.com/ostafen/clover/v2/query"
 	uuid "github.com/satori/go.uuid"
 	"log"
+	"strings"
 )
 
 const (
@@ -40,9 +42,14 @@ func NewStore(db *clover.DB) *Store {
 			log.Fatal("failed to create id index for collection:", collection, err)
 		}
 	}
+
+	createInvites(db)
+	createSanctions(db)
 	return &Store{db: db}
 }
 
+// Put stores a new room under a new id, which is returned. Any id the room
+// already has is ignored.
 func (s *Store) Put(r cicada.Room) (string, error) {
 	docId := uuid.NewV4().String()
 	r.Id = docId
@@ -56,6 +63,16 @@ func (s *Store) Put(r cicada.Room) (string, error) {
 	return docId, err
 }
 
+// PutDirect stores a new direct conversation under the id derived from its
+// participants. Only direct conversations keep the id they are given.
+func (s *Store) PutDirect(r cicada.Room) error {
+	if !r.Direct || len(r.Id) == 0 {
+		return fmt.Errorf("%w: not a direct conversation", cicada.ErrorBadRequest)
+	}
+	_, err := s.db.InsertOne(collection, document.NewDocumentOf(r))
+	return err
+}
+
 func (s *Store) Update(r cicada.Room) error {
 	q := query.NewQuery(collection).Where(query.Field("id").Eq(r.Id))
 	doc := document.NewDocumentOf(r)
@@ -78,6 +95,24 @@ func (s *Store) GetForUser(id string) ([]cicada.Room, error) {
 	return rooms, nil
 }
 
+// ForEach calls fn with every stored room, stopping at the first error.
+func (s *Store) ForEach(fn func(r cicada.Room) error) error {
+	var err error
+	e := s.db.ForEach(query.NewQuery(collection), func(doc *document.Document) bool {
+		r := cicada.Room{}
+		if err = doc.Unmarshal(&r); err == nil {
+			err = fn(r)
+		}
+		return err == nil
+	})
+
+	if err != nil {
+		return err
+	}
+	return processError(e)
+}
+
+// Delete removes a room, the invites to it and its sanctions.
 func (s *Store) Delete(id string) error {
 	q := query.NewQuery(collection).Where(query.Field("id").Eq(id))
 	var err error
@@ -89,6 +124,12 @@ func (s *Store) Delete(id string) error {
 	if err == nil {
 		err = s.db.Delete(q)
 	}
+	if err == nil {
+		err = s.db.Delete(query.NewQuery(invites).Where(query.Field("roomId").Eq(id)))
+	}
+	if err == nil {
+		err = s.db.Delete(query.NewQuery(sanctions).Where(query.Field("roomId").Eq(id)))
+	}
 	return processError(err)
 }
 
@@ -107,23 +148,62 @@ func (s *Store) Get(id string) (cicada.Room, error) {
 	return r, err
 }
 
-func (s *Store) GetAll() ([]cicada.Room, error) {
-	q := query.NewQuery(collection)
-	docs, err := s.db.FindAll(q)
-	if e := processError(err); e != nil {
-		return nil, e
+// GetAll lists the public rooms whose name or description contains the
+// search text, ignoring case, sorted by name. It skips the first from rooms
+// and returns at most size. Rooms without a visibility are public, and direct
+// conversations never are.
+func (s *Store) GetAll(search string, from, size int) ([]cicada.Room, error) {
+	if from < 0 || size <= 0 {
+		return nil, cicada.ErrorBadRequest
 	}
 
-	rooms := make([]cicada.Room, len(docs))
-	for i, d := range docs {
+	search = strings.ToLower(search)
+	q := query.NewQuery(collection).
+		Sort(query.SortOption{Field: "name", Direction: 1}, query.SortOption{Field: "id", Direction: 1})
+
+	rooms := make([]cicada.Room, 0, size)
+	skipped := 0
+	var err error
+	e := s.db.ForEach(q, func(doc *document.Document) bool {
+		// clover sorts on two fields in memory and keeps passing documents
+		// after the page is full or decoding failed, so skip them before
+		// decoding
+		if err != nil || len(rooms) == size {
+			return false
+		}
+
 		r := cicada.Room{}
-		err = d.Unmarshal(&r)
-		if err != nil {
-			break
+		if err = doc.Unmarshal(&r); err != nil {
+			return false
+		}
+
+		if !listed(r, search) {
+			return true
 		}
-		rooms[i] = r
+		if skipped < from {
+			skipped++
+			return true
+		}
+		rooms = append(rooms, r)
+		return len(rooms) < size
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	if e = processError(e); e != nil {
+		return nil, e
+	}
+	return rooms, nil
+}
+
+// listed reports whether a room belongs in the directory for a lower case
+// search text.
+func listed(r cicada.Room, search string) bool {
+	if r.Direct || (len(r.Visibility) != 0 && r.Visibility != cicada.VisibilityPublic) {
+		return false
 	}
-	return rooms, err
+	return strings.Contains(strings.ToLower(r.Name), search) || strings.Contains(strings.ToLower(r.Description), search)
 }
 
 func processError(e error) error {
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func database() (*clover.DB, string) {
@@ -33,6 +34,7 @@ func TestRoundTrip(t *testing.T) {
 		Name:        "Water Cooler",
 		Description: "Idle chit chat",
 		Members:     []string{"user1", "user2"},
+		Roles:       map[string]string{"user1": cicada.RoleOwner},
 	}
 
 	id, err := store.Put(room)
@@ -68,6 +70,10 @@ func TestRoundTrip(t *testing.T) {
 	if !reflect.DeepEqual(room.Members, newRoom.Members) {
 		t.Errorf("members didn't round trip, expected '%+v' got '%+v'", room.Members, newRoom.Members)
 	}
+
+	if !reflect.DeepEqual(room.Roles, newRoom.Roles) {
+		t.Errorf("roles didn't round trip, expected '%+v' got '%+v'", room.Roles, newRoom.Roles)
+	}
 }
 
 func TestUpdate(t *testing.T) {
@@ -198,7 +204,7 @@ func TestGetAll(t *testing.T) {
 		rooms1[i].Id = id
 	}
 
-	rooms2, err := store.GetAll()
+	rooms2, err := store.GetAll("", 0, 10)
 	if err != nil {
 		t.Fatal("error fetching all rooms", err)
 	}
@@ -238,7 +244,7 @@ func TestGetAllEmpty(t *testing.T) {
 	defer os.Remove(filepath.Join(dir, "data.db"))
 
 	store := NewStore(db)
-	rooms, err := store.GetAll()
+	rooms, err := store.GetAll("", 0, 10)
 	if err != nil {
 		t.Fatal("get all errored", err)
 	}
@@ -265,3 +271,255 @@ func TestDeleteBad(t *testing.T) {
 	}
 
 }
+
+func TestDirect(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	store := NewStore(db)
+	direct := cicada.Room{
+		Id:           "c1d0a0b4-5f51-5e3c-9d52-1f1a8f2b7e10",
+		Members:      []string{"user1", "user2"},
+		Direct:       true,
+		Participants: []string{"user1", "user2"},
+	}
+	if err := store.PutDirect(direct); err != nil {
+		t.Fatal("unable to store room", err)
+	}
+	id := direct.Id
+	if err := store.PutDirect(cicada.Room{Id: "c1d0a0b4-5f51-5e3c-9d52-1f1a8f2b7e11", Name: "Plain"}); !errors.Is(err, cicada.ErrorBadRequest) {
+		t.Error("expected a room that is not direct to be refused, got", err)
+	}
+	cooler, err := store.Put(cicada.Room{Id: id, Name: "Water Cooler", Members: []string{"user1"}})
+	if err != nil {
+		t.Fatal("unable to store room", err)
+	}
+	if cooler == id {
+		t.Error("expected a new id for a room that is not direct")
+	}
+
+	r, err := store.Get(id)
+	if err != nil {
+		t.Fatal("error fetching direct room", err)
+	}
+	if !r.Direct || !reflect.DeepEqual(r.Participants, direct.Participants) {
+		t.Error("direct room did not round trip", r)
+	}
+
+	rooms, err := store.GetAll("", 0, 10)
+	if err != nil {
+		t.Fatal("error fetching all rooms", err)
+	}
+	if len(rooms) != 1 || rooms[0].Direct {
+		t.Error("expected only the water cooler to be listed, got", rooms)
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	store := NewStore(db)
+	for _, r := range []cicada.Room{
+		{Name: "Water Cooler", Description: "Idle chit chat"},
+		{Name: "Design", Description: "Talk about the new service", Visibility: cicada.VisibilityPublic},
+		{Name: "Announcements", Description: "News about the service"},
+		{Name: "Secret Service", Visibility: cicada.VisibilityPrivate},
+		{Name: "Service Invites", Visibility: cicada.VisibilityInvite},
+	} {
+		if _, err := store.Put(r); err != nil {
+			t.Fatal("unable to store room", err)
+		}
+	}
+
+	names := func(rooms []cicada.Room) []string {
+		result := []string{}
+		for _, r := range rooms {
+			result = append(result, r.Name)
+		}
+		return result
+	}
+
+	for _, test := range []struct {
+		search   string
+		from     int
+		size     int
+		expected []string
+	}{
+		{"", 0, 10, []string{"Announcements", "Design", "Water Cooler"}},
+		{"SERVICE", 0, 10, []string{"Announcements", "Design"}},
+		{"", 1, 1, []string{"Design"}},
+		{"", 0, 2, []string{"Announcements", "Design"}},
+		{"", 3, 10, []string{}},
+		{"", 4, 10, []string{}},
+		{"", 100, 1, []string{}},
+		{"service", 2, 10, []string{}},
+		{"nothing", 0, 10, []string{}},
+	} {
+		rooms, err := store.GetAll(test.search, test.from, test.size)
+		if err != nil {
+			t.Fatal("error listing rooms", err)
+		}
+		if !reflect.DeepEqual(names(rooms), test.expected) {
+			t.Errorf("search %q from %d: expected %v, got %v", test.search, test.from, test.expected, names(rooms))
+		}
+	}
+}
+
+func TestInvites(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	store := NewStore(db)
+	id, err := store.Put(cicada.Room{Name: "Secret", Visibility: cicada.VisibilityPrivate})
+	if err != nil {
+		t.Fatal("unable to store room", err)
+	}
+
+	inv := cicada.Invite{
+		Token:     "5a0c3e4b-0a7d-4c1e-8f57-2b9d1e6f3a10",
+		RoomId:    id,
+		CreatedBy: "user1",
+		Expires:   time.Now().Add(time.Hour),
+	}
+	if err = store.PutInvite(inv); err != nil {
+		t.Fatal("unable to store invite", err)
+	}
+
+	stored, err := store.GetInvite(inv.Token)
+	if err != nil {
+		t.Fatal("error fetching invite", err)
+	}
+	if stored.RoomId != id || stored.CreatedBy != "user1" || !stored.Expires.Equal(inv.Expires) {
+		t.Error("invite did not round trip", stored)
+	}
+
+	if _, err = store.GetInvite("not a token"); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected not found for a malformed token, got", err)
+	}
+
+	if err = store.Delete(id); err != nil {
+		t.Fatal("error deleting room", err)
+	}
+	if _, err = store.GetInvite(inv.Token); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected invites to be deleted with their room, got", err)
+	}
+}
+
+func TestSanctions(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	store := NewStore(db)
+	id, err := store.Put(cicada.Room{Name: "Rowdy"})
+	if err != nil {
+		t.Fatal("unable to store room", err)
+	}
+
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	list := []cicada.Sanction{
+		{Id: "0b6f8a52-3c7e-4f0d-9a51-6e2d8c4b7f10", RoomId: id, UserId: "user2", Action: cicada.SanctionMute, By: "user1", Date: now.Add(-2 * time.Minute), Expires: &expires},
+		{Id: "1c7a9b63-4d8f-4a1e-8b62-7f3e9d5c8a21", RoomId: id, UserId: "user3", Action: cicada.SanctionKick, By: "user1", Date: now.Add(-time.Minute)},
+		{Id: "2d8bac74-5e9a-4b2f-9c73-8a4fae6d9b32", RoomId: id, UserId: "user2", Action: cicada.SanctionBan, By: "user1", Reason: "spam", Date: now},
+	}
+	for _, sa := range list {
+		if err = store.PutSanction(sa); err != nil {
+			t.Fatal("unable to store sanction", err)
+		}
+	}
+
+	all, err := store.Sanctions(id, "")
+	if err != nil {
+		t.Fatal("error listing sanctions", err)
+	}
+	if len(all) != 3 || all[0].Id != list[0].Id || all[2].Id != list[2].Id {
+		t.Error("expected all sanctions oldest first, got", all)
+	}
+
+	user2, err := store.Sanctions(id, "user2")
+	if err != nil {
+		t.Fatal("error listing sanctions", err)
+	}
+	if len(user2) != 2 || !user2[0].Active(now) || !user2[1].Active(now) {
+		t.Error("expected two active sanctions against user2, got", user2)
+	}
+	if user2[0].Active(expires.Add(time.Second)) {
+		t.Error("expected mute to have expired")
+	}
+	if all[1].Active(now) {
+		t.Error("expected a kick never to be active")
+	}
+
+	ban := user2[1]
+	ban.Revoked = &now
+	ban.RevokedBy = "user1"
+	if err = store.ReplaceSanction(ban); err != nil {
+		t.Fatal("error revoking sanction", err)
+	}
+	stored, err := store.GetSanction(ban.Id)
+	if err != nil {
+		t.Fatal("error fetching sanction", err)
+	}
+	if stored.Active(now) || stored.RevokedBy != "user1" || stored.Reason != "spam" {
+		t.Error("revocation did not round trip", stored)
+	}
+
+	if err = store.Delete(id); err != nil {
+		t.Fatal("error deleting room", err)
+	}
+	if _, err = store.GetSanction(ban.Id); !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected sanctions to be deleted with their room, got", err)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	store := NewStore(db)
+	room := cicada.Room{
+		Name:      "Records",
+		Members:   []string{"user1"},
+		Retention: &cicada.Retention{MaxAge: 30 * 24 * time.Hour, MaxMessages: 1000},
+		LegalHold: true,
+	}
+	id, err := store.Put(room)
+	if err != nil {
+		t.Fatal("error saving room", err)
+	}
+	if _, err = store.Put(cicada.Room{Name: "Plain"}); err != nil {
+		t.Fatal("error saving room", err)
+	}
+
+	stored, err := store.Get(id)
+	if err != nil {
+		t.Fatal("error fetching room", err)
+	}
+	if stored.Retention == nil || *stored.Retention != *room.Retention || !stored.LegalHold {
+		t.Errorf("retention didn't round trip, expected %+v got %+v", room, stored)
+	}
+
+	stored.Retention = nil
+	stored.LegalHold = false
+	if err = store.Update(stored); err != nil {
+		t.Fatal("failed to update room", err)
+	}
+	if stored, _ = store.Get(id); stored.Retention != nil || stored.LegalHold {
+		t.Errorf("expected retention and legal hold to be cleared, got %+v", stored)
+	}
+
+	var names []string
+	err = store.ForEach(func(r cicada.Room) error {
+		names = append(names, r.Name)
+		return nil
+	})
+	if err != nil || len(names) != 2 {
+		t.Error("expected to visit both rooms, got", names, err)
+	}
+}
@@ -0,0 +1,121 @@
+package search
+
+import (
+	"strings"
+	"time"
+)
+
+// Query selects messages containing every word and phrase of a search, in
+// the given rooms and optionally from one sender or within a span of time.
+type Query struct {
+	// Words must each appear somewhere in a message.
+	Words []string
+	// Phrases must each appear in a message with their words in order.
+	Phrases [][]string
+	// Rooms limits the search to these rooms. Messages in other rooms are
+	// never found.
+	Rooms map[string]bool
+	// Sender, when set, limits the search to one user's messages.
+	Sender string
+	// After and Before, when set, limit the search to messages sent in
+	// between them.
+	After, Before time.Time
+	// Limit caps the number of hits. Zero returns every hit.
+	Limit int
+}
+
+// ParseQuery splits search text into words and double quoted phrases. An
+// unterminated quote runs to the end of the text.
+func ParseQuery(text string) Query {
+	q := Query{}
+	for i, part := range strings.Split(text, `"`) {
+		terms := []string{}
+		for _, t := range tokenize(part) {
+			terms = append(terms, t.term)
+		}
+
+		if i%2 == 0 || len(terms) == 1 {
+			q.Words = append(q.Words, terms...)
+		} else if len(terms) > 1 {
+			q.Phrases = append(q.Phrases, terms)
+		}
+	}
+	return q
+}
+
+// Terms lists every distinct term in the query, which are also the words to
+// highlight in results.
+func (q Query) Terms() []string {
+	seen := make(map[string]bool)
+	terms := []string{}
+	add := func(term string) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+
+	for _, w := range q.Words {
+		add(w)
+	}
+	for _, phrase := range q.Phrases {
+		for _, w := range phrase {
+			add(w)
+		}
+	}
+	return terms
+}
+
+// accepts reports whether a message passes the query's filters.
+func (q Query) accepts(p posting) bool {
+	if !q.Rooms[p.RoomId] {
+		return false
+	}
+	if len(q.Sender) != 0 && p.Sender != q.Sender {
+		return false
+	}
+	if !q.After.IsZero() && !p.Date.After(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && !p.Date.Before(q.Before) {
+		return false
+	}
+	return true
+}
+
+// hasPhrases reports whether every phrase appears in a message, given the
+// postings of each of the query's terms.
+func (q Query) hasPhrases(messageId string, matches map[string]map[string]posting) bool {
+	for _, phrase := range q.Phrases {
+		if !hasPhrase(phrase, func(term string) []int { return matches[term][messageId].Positions }) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPhrase looks for a start position of the first word that each later
+// word follows in turn.
+func hasPhrase(phrase []string, positions func(term string) []int) bool {
+	at := make([]map[int]bool, len(phrase))
+	for i, term := range phrase {
+		at[i] = make(map[int]bool)
+		for _, p := range positions(term) {
+			at[i][p] = true
+		}
+	}
+
+	for start := range at[0] {
+		found := true
+		for i := 1; i < len(phrase); i++ {
+			if !at[i][start+i] {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
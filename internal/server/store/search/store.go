@@ -0,0 +1,284 @@
+// Package search keeps an inverted index of message text, so messages can be
+// found by the words in them. The index only holds what is needed to match
+// and filter messages; the messages themselves stay in the chat store, and
+// the index can be rebuilt from it at any time.
+package search
+
+import (
+	"cicada"
+	"encoding/json"
+	"errors"
+	"fmt"
+	badger "github.com/dgraph-io/badger/v4"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// termPrefix keys a posting, t/<term>/<message id>, holding where the
+	// term appears in the message.
+	termPrefix = "t/"
+	// docPrefix keys d/<message id>, holding the terms indexed for a message
+	// so they can be removed when it changes.
+	docPrefix = "d/"
+	// roomPrefix keys r/<room id>/<message id>, so a room's messages can be
+	// removed together.
+	roomPrefix = "r/"
+	// batchSize bounds the number of keys deleted in one transaction.
+	batchSize = 1000
+)
+
+// posting records a message containing a term, with enough about the
+// message to filter on without reading it.
+type posting struct {
+	RoomId    string    `json:"roomId"`
+	Sender    string    `json:"sender"`
+	Date      time.Time `json:"date"`
+	Positions []int     `json:"positions"`
+}
+
+// doc records what was indexed for a message.
+type doc struct {
+	RoomId string   `json:"roomId"`
+	Terms  []string `json:"terms"`
+}
+
+// Hit is a message that matched a query.
+type Hit struct {
+	MessageId string
+	RoomId    string
+	Date      time.Time
+}
+
+type Store struct {
+	db *badger.DB
+}
+
+func NewStore(db *badger.DB) *Store {
+	return &Store{db: db}
+}
+
+// Index adds a message to the index, replacing anything indexed for it
+// before. Deleted messages are removed instead.
+func (s *Store) Index(m cicada.ChatMessage) error {
+	if m.Deleted {
+		return s.Remove(m.Id)
+	}
+
+	positions := make(map[string][]int)
+	terms := []string{}
+	for i, t := range tokenize(m.Text) {
+		if _, ok := positions[t.term]; !ok {
+			terms = append(terms, t.term)
+		}
+		positions[t.term] = append(positions[t.term], i)
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		if err := removeDoc(txn, m.Id); err != nil {
+			return err
+		}
+
+		for _, term := range terms {
+			p := posting{RoomId: m.RoomId, Sender: m.Sender, Date: m.Date, Positions: positions[term]}
+			if err := setJson(txn, termKey(term, m.Id), p); err != nil {
+				return err
+			}
+		}
+
+		if err := txn.Set(roomKey(m.RoomId, m.Id), nil); err != nil {
+			return err
+		}
+		return setJson(txn, docKey(m.Id), doc{RoomId: m.RoomId, Terms: terms})
+	})
+}
+
+// Remove drops a message from the index.
+func (s *Store) Remove(messageId string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return removeDoc(txn, messageId)
+	})
+}
+
+// RemoveRoom drops every message of a room from the index.
+func (s *Store) RemoveRoom(roomId string) error {
+	prefix := []byte(roomPrefix + roomId + "/")
+	for {
+		ids := []string{}
+		err := s.db.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+			defer it.Close()
+
+			for it.Seek(prefix); it.ValidForPrefix(prefix) && len(ids) < batchSize; it.Next() {
+				ids = append(ids, strings.TrimPrefix(string(it.Item().Key()), string(prefix)))
+			}
+			return nil
+		})
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		err = s.db.Update(func(txn *badger.Txn) error {
+			for _, id := range ids {
+				if err := removeDoc(txn, id); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Clear removes everything from the index, before it is rebuilt.
+func (s *Store) Clear() error {
+	return s.db.DropAll()
+}
+
+// Empty reports whether nothing has been indexed.
+func (s *Store) Empty() (bool, error) {
+	empty := true
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := []byte(docPrefix)
+		it.Seek(prefix)
+		empty = !it.ValidForPrefix(prefix)
+		return nil
+	})
+	return empty, err
+}
+
+// Search finds the messages matching a query, newest first.
+func (s *Store) Search(q Query) ([]Hit, error) {
+	terms := q.Terms()
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("%w: search has no words", cicada.ErrorBadRequest)
+	}
+
+	var matches map[string]map[string]posting
+	err := s.db.View(func(txn *badger.Txn) error {
+		matches = make(map[string]map[string]posting, len(terms))
+		for _, term := range terms {
+			postings, err := readPostings(txn, term, q)
+			if err != nil {
+				return err
+			}
+			if len(postings) == 0 {
+				// every term must match, so there are no results
+				matches = nil
+				return nil
+			}
+			matches[term] = postings
+		}
+		return nil
+	})
+	if err != nil || matches == nil {
+		return []Hit{}, err
+	}
+
+	// walk the rarest term's postings, keeping messages with every other term
+	slices.SortFunc(terms, func(a, b string) int { return len(matches[a]) - len(matches[b]) })
+	hits := []Hit{}
+	for id, p := range matches[terms[0]] {
+		found := true
+		for _, term := range terms[1:] {
+			if _, ok := matches[term][id]; !ok {
+				found = false
+				break
+			}
+		}
+
+		if found && q.hasPhrases(id, matches) {
+			hits = append(hits, Hit{MessageId: id, RoomId: p.RoomId, Date: p.Date})
+		}
+	}
+
+	slices.SortFunc(hits, func(a, b Hit) int {
+		if c := b.Date.Compare(a.Date); c != 0 {
+			return c
+		}
+		return strings.Compare(b.MessageId, a.MessageId)
+	})
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, nil
+}
+
+// readPostings reads the messages containing a term that pass the query's
+// filters.
+func readPostings(txn *badger.Txn, term string, q Query) (map[string]posting, error) {
+	postings := make(map[string]posting)
+	prefix := []byte(termPrefix + term + "/")
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix, PrefetchValues: true, PrefetchSize: 100})
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		p := posting{}
+		err := it.Item().Value(func(val []byte) error {
+			return json.Unmarshal(val, &p)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if q.accepts(p) {
+			postings[strings.TrimPrefix(string(it.Item().Key()), string(prefix))] = p
+		}
+	}
+	return postings, nil
+}
+
+// removeDoc deletes a message's postings, if it was indexed.
+func removeDoc(txn *badger.Txn, messageId string) error {
+	item, err := txn.Get(docKey(messageId))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	d := doc{}
+	if err = item.Value(func(val []byte) error { return json.Unmarshal(val, &d) }); err != nil {
+		return err
+	}
+
+	for _, term := range d.Terms {
+		if err = txn.Delete(termKey(term, messageId)); err != nil {
+			return err
+		}
+	}
+	if err = txn.Delete(roomKey(d.RoomId, messageId)); err != nil {
+		return err
+	}
+	return txn.Delete(docKey(messageId))
+}
+
+func setJson(txn *badger.Txn, key []byte, value any) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return txn.Set(key, b)
+}
+
+func termKey(term, messageId string) []byte {
+	return []byte(termPrefix + term + "/" + messageId)
+}
+
+func docKey(messageId string) []byte {
+	return []byte(docPrefix + messageId)
+}
+
+func roomKey(roomId, messageId string) []byte {
+	return []byte(roomPrefix + roomId + "/" + messageId)
+}
@@ -0,0 +1,164 @@
+package search
+
+import (
+	"cicada"
+	"github.com/dgraph-io/badger/v4"
+	uuid "github.com/satori/go.uuid"
+	"log"
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// database opens badger in a fresh temporary directory. Callers must close the
+// database before removing the directory, or badger blocks flushing to it.
+func database() (*badger.DB, string) {
+	dbDir, err := os.MkdirTemp("", "cicada-search")
+	if err != nil {
+		log.Fatal("unable to create temp dir", err)
+	}
+	slog.Info("using temp dir", "dir", dbDir)
+	db, err := badger.Open(badger.DefaultOptions(dbDir))
+	if err != nil {
+		log.Fatal("unable to open database", err)
+	}
+	return db, dbDir
+}
+
+func message(roomId, sender, text string, date time.Time) cicada.ChatMessage {
+	return cicada.ChatMessage{Id: uuid.NewV4().String(), RoomId: roomId, Sender: sender, Text: text, Date: date}
+}
+
+func ids(hits []Hit) []string {
+	result := make([]string, len(hits))
+	for i, h := range hits {
+		result[i] = h.MessageId
+	}
+	return result
+}
+
+func TestSearch(t *testing.T) {
+	db, dbDir := database()
+	defer os.RemoveAll(dbDir)
+	defer db.Close()
+
+	s := NewStore(db)
+	now := time.Now()
+	messages := []cicada.ChatMessage{
+		message("a", "alice", "The crow flies at midnight", now),
+		message("a", "bob", "Midnight is when the crow flies", now.Add(time.Second)),
+		message("b", "alice", "the crow flies at midnight", now.Add(2*time.Second)),
+		message("a", "bob", "nothing to see here", now.Add(3*time.Second)),
+	}
+	for _, m := range messages {
+		if err := s.Index(m); err != nil {
+			t.Fatal("error indexing message", err)
+		}
+	}
+
+	rooms := map[string]bool{"a": true}
+	for _, test := range []struct {
+		name string
+		q    Query
+		want []cicada.ChatMessage
+	}{
+		{"words", Query{Words: []string{"crow", "midnight"}}, []cicada.ChatMessage{messages[1], messages[0]}},
+		{"phrase", ParseQuery(`"crow flies at"`), []cicada.ChatMessage{messages[0]}},
+		{"case", ParseQuery("MIDNIGHT"), []cicada.ChatMessage{messages[1], messages[0]}},
+		{"sender", Query{Words: []string{"crow"}, Sender: "bob"}, []cicada.ChatMessage{messages[1]}},
+		{"after", Query{Words: []string{"crow"}, After: now}, []cicada.ChatMessage{messages[1]}},
+		{"before", Query{Words: []string{"crow"}, Before: now.Add(time.Second)}, []cicada.ChatMessage{messages[0]}},
+		{"limit", Query{Words: []string{"crow"}, Limit: 1}, []cicada.ChatMessage{messages[1]}},
+		{"missing", ParseQuery("crow owl"), nil},
+	} {
+		test.q.Rooms = rooms
+		hits, err := s.Search(test.q)
+		if err != nil {
+			t.Fatal(test.name, "error searching", err)
+		}
+
+		want := []string{}
+		for _, m := range test.want {
+			want = append(want, m.Id)
+		}
+		if !slices.Equal(ids(hits), want) {
+			t.Error(test.name, "expected", want, "got", ids(hits))
+		}
+	}
+
+	if _, err := s.Search(Query{Rooms: rooms}); err == nil {
+		t.Error("expected an error searching for nothing")
+	}
+}
+
+func TestReindex(t *testing.T) {
+	db, dbDir := database()
+	defer os.RemoveAll(dbDir)
+	defer db.Close()
+
+	s := NewStore(db)
+	empty, err := s.Empty()
+	if err != nil || !empty {
+		t.Fatal("expected a new index to be empty", err)
+	}
+
+	m := message("a", "alice", "the crow flies", time.Now())
+	other := message("b", "alice", "the crow flies", time.Now())
+	for _, msg := range []cicada.ChatMessage{m, other} {
+		if err = s.Index(msg); err != nil {
+			t.Fatal("error indexing message", err)
+		}
+	}
+
+	rooms := map[string]bool{"a": true, "b": true}
+	m.Text = "the owl flies"
+	if err = s.Index(m); err != nil {
+		t.Fatal("error indexing edited message", err)
+	}
+	if hits, _ := s.Search(Query{Words: []string{"crow"}, Rooms: rooms}); !slices.Equal(ids(hits), []string{other.Id}) {
+		t.Error("expected the edited text to replace the old, got", ids(hits))
+	}
+	if hits, _ := s.Search(Query{Words: []string{"owl"}, Rooms: rooms}); !slices.Equal(ids(hits), []string{m.Id}) {
+		t.Error("expected the edited text to be found, got", ids(hits))
+	}
+
+	m.Deleted = true
+	if err = s.Index(m); err != nil {
+		t.Fatal("error indexing deleted message", err)
+	}
+	if hits, _ := s.Search(Query{Words: []string{"flies"}, Rooms: rooms}); !slices.Equal(ids(hits), []string{other.Id}) {
+		t.Error("expected the deleted message to be removed, got", ids(hits))
+	}
+
+	if err = s.RemoveRoom("b"); err != nil {
+		t.Fatal("error removing room", err)
+	}
+	empty, err = s.Empty()
+	if err != nil || !empty {
+		t.Error("expected the index to be empty once every message is removed", err)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	parts := Snippet("The crow flies at midnight", []string{"crow", "midnight"})
+	want := []cicada.SnippetPart{
+		{Text: "The "},
+		{Text: "crow", Match: true},
+		{Text: " flies at "},
+		{Text: "midnight", Match: true},
+	}
+	if !slices.Equal(parts, want) {
+		t.Error("expected", want, "got", parts)
+	}
+
+	long := ""
+	for i := 0; i < 50; i++ {
+		long += "filler "
+	}
+	parts = Snippet(long+"needle "+long, []string{"needle"})
+	if len(parts) != 3 || !parts[1].Match || parts[0].Text[:len(ellipsis)] != ellipsis || parts[2].Text[len(parts[2].Text)-len(ellipsis):] != ellipsis {
+		t.Error("expected a match cut from the middle of the text, got", parts)
+	}
+}
@@ -0,0 +1,132 @@
+package search
+
+import (
+	"cicada"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxTermLength bounds the length of an indexed term, in characters.
+	// Longer words are cut, so they still match a search for their start.
+	maxTermLength = 64
+	// snippetLead is how much text, in characters, a snippet shows before
+	// the first match.
+	snippetLead = 40
+	// snippetLength is the longest snippet, in characters.
+	snippetLength = 160
+	ellipsis      = "…"
+)
+
+// token is a word in a message, with its byte offsets in the text.
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lower case words of letters and digits.
+func tokenize(text string) []token {
+	tokens := []token{}
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		} else if !word && start >= 0 {
+			tokens = append(tokens, newToken(text, start, i))
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, newToken(text, start, len(text)))
+	}
+	return tokens
+}
+
+func newToken(text string, start, end int) token {
+	term := strings.ToLower(text[start:end])
+	if utf8.RuneCountInString(term) > maxTermLength {
+		term = string([]rune(term)[:maxTermLength])
+	}
+	return token{term: term, start: start, end: end}
+}
+
+// Snippet cuts an excerpt of text around the first word matching one of
+// terms, splitting it into runs so matching words can be highlighted.
+func Snippet(text string, terms []string) []cicada.SnippetPart {
+	match := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		match[t] = true
+	}
+
+	tokens := tokenize(text)
+	first := len(text)
+	for _, t := range tokens {
+		if match[t.term] {
+			first = t.start
+			break
+		}
+	}
+	if first == len(text) {
+		first = 0
+	}
+
+	from := backRunes(text, first, snippetLead)
+	to := forwardRunes(text, from, snippetLength)
+
+	parts := []cicada.SnippetPart{}
+	add := func(s string, m bool) {
+		if len(s) != 0 {
+			parts = append(parts, cicada.SnippetPart{Text: s, Match: m})
+		}
+	}
+
+	prefix := ""
+	if from > 0 {
+		prefix = ellipsis
+	}
+	pos := from
+	for _, t := range tokens {
+		if t.start < from || t.end > to || !match[t.term] {
+			continue
+		}
+		add(prefix+text[pos:t.start], false)
+		add(text[t.start:t.end], true)
+		prefix = ""
+		pos = t.end
+	}
+
+	suffix := ""
+	if to < len(text) {
+		suffix = ellipsis
+	}
+	add(prefix+text[pos:to]+suffix, false)
+	return parts
+}
+
+// backRunes returns the byte offset n characters before i, stopping at the
+// start of a word so the snippet does not begin mid word.
+func backRunes(text string, i, n int) int {
+	for ; n > 0 && i > 0; n-- {
+		_, size := utf8.DecodeLastRuneInString(text[:i])
+		i -= size
+	}
+	for i > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:i])
+		if unicode.IsSpace(r) {
+			break
+		}
+		i -= size
+	}
+	return i
+}
+
+// forwardRunes returns the byte offset n characters after i.
+func forwardRunes(text string, i, n int) int {
+	for ; n > 0 && i < len(text); n-- {
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+	}
+	return i
+}
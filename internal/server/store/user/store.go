@@ -0,0 +1,193 @@
+package user
+
+import (
+	"cicada"
+	"errors"
+	"github.com/ostafen/clover/v2"
+	"github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/query"
+	uuid "github.com/satori/go.uuid"
+	"log"
+	"slices"
+)
+
+const (
+	collection    = "users"
+	passwordField = "passwordHash"
+)
+
+type Store struct {
+	db *clover.DB
+}
+
+func NewStore(db *clover.DB) *Store {
+	exists, err := db.HasCollection(collection)
+	if err != nil {
+		log.Fatal("failed to create collection", collection, err)
+	}
+
+	if !exists {
+		err := db.CreateCollection(collection)
+		if err != nil {
+			log.Fatal("failed to create collection", collection, err)
+		}
+
+		err = db.CreateIndex(collection, "id")
+		if err != nil {
+			log.Fatal("failed to create id index for collection:", collection, err)
+		}
+
+		err = db.CreateIndex(collection, "name")
+		if err != nil {
+			log.Fatal("failed to create name index for collection:", collection, err)
+		}
+	}
+	return &Store{db: db}
+}
+
+func (s *Store) Put(u cicada.User) (string, error) {
+	docId := uuid.NewV4().String()
+	u.Id = docId
+	if u.Rooms == nil {
+		u.Rooms = []string{}
+	}
+	doc := document.NewDocumentOf(u)
+	_, err := s.db.InsertOne(collection, doc)
+
+	if err != nil {
+		docId = ""
+	}
+
+	return docId, err
+}
+
+func (s *Store) Get(id string) (cicada.User, error) {
+	u := cicada.User{}
+	q := query.NewQuery(collection).Where(query.Field("id").Eq(id))
+	doc, err := s.db.FindFirst(q)
+	if e := processError(err); e != nil {
+		return u, e
+	}
+
+	if doc == nil {
+		return u, cicada.ErrorNotFound
+	}
+
+	err = doc.Unmarshal(&u)
+	return u, err
+}
+
+// GetByName finds the user with the given name.
+func (s *Store) GetByName(name string) (cicada.User, error) {
+	u := cicada.User{}
+	q := query.NewQuery(collection).Where(query.Field("name").Eq(name))
+	doc, err := s.db.FindFirst(q)
+	if e := processError(err); e != nil {
+		return u, e
+	}
+
+	if doc == nil {
+		return u, cicada.ErrorNotFound
+	}
+
+	err = doc.Unmarshal(&u)
+	return u, err
+}
+
+// SetPassword stores the password hash for a user. The hash is kept on the
+// user document but is never part of cicada.User.
+func (s *Store) SetPassword(id string, hash []byte) error {
+	q := query.NewQuery(collection).Where(query.Field("id").Eq(id))
+	if exists, err := s.db.Exists(q); err != nil || !exists {
+		return notFound(err)
+	}
+
+	err := s.db.Update(q, map[string]interface{}{passwordField: string(hash)})
+	return processError(err)
+}
+
+// PasswordHash returns the password hash stored for a user.
+func (s *Store) PasswordHash(id string) ([]byte, error) {
+	q := query.NewQuery(collection).Where(query.Field("id").Eq(id))
+	doc, err := s.db.FindFirst(q)
+	if e := processError(err); e != nil {
+		return nil, e
+	}
+
+	if doc == nil {
+		return nil, cicada.ErrorNotFound
+	}
+
+	hash, _ := doc.Get(passwordField).(string)
+	if len(hash) == 0 {
+		return nil, cicada.ErrorNotFound
+	}
+	return []byte(hash), nil
+}
+
+func (s *Store) Update(u cicada.User) error {
+	q := query.NewQuery(collection).Where(query.Field("id").Eq(u.Id))
+	if exists, err := s.db.Exists(q); err != nil || !exists {
+		return notFound(err)
+	}
+
+	doc := document.NewDocumentOf(u)
+	err := s.db.Update(q, doc.AsMap())
+	return processError(err)
+}
+
+// AddRoom records that the user is a member of the room.
+func (s *Store) AddRoom(id, roomId string) error {
+	u, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(u.Rooms, roomId) {
+		return nil
+	}
+	u.Rooms = append(u.Rooms, roomId)
+	return s.Update(u)
+}
+
+// RemoveRoom records that the user is no longer a member of the room.
+func (s *Store) RemoveRoom(id, roomId string) error {
+	u, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	i := slices.Index(u.Rooms, roomId)
+	if i < 0 {
+		return nil
+	}
+	u.Rooms = slices.Delete(u.Rooms, i, i+1)
+	return s.Update(u)
+}
+
+func (s *Store) Delete(id string) error {
+	q := query.NewQuery(collection).Where(query.Field("id").Eq(id))
+	if exists, err := s.db.Exists(q); err != nil || !exists {
+		return notFound(err)
+	}
+	return processError(s.db.Delete(q))
+}
+
+func notFound(e error) error {
+	if e != nil {
+		return processError(e)
+	}
+	return cicada.ErrorNotFound
+}
+
+func processError(e error) error {
+	if e == nil {
+		return nil
+	}
+
+	if errors.Is(e, clover.ErrDocumentNotExist) {
+		return cicada.ErrorNotFound
+	}
+
+	return e
+}
@@ -0,0 +1,224 @@
+package user
+
+import (
+	"cicada"
+	"errors"
+	"github.com/ostafen/clover/v2"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func database() (*clover.DB, string) {
+	dbDir := os.TempDir()
+	slog.Info("using temp dir", "dir", dbDir)
+	db, err := clover.Open(dbDir)
+	if err != nil {
+		log.Fatal("unable to open database", err)
+	}
+	return db, dbDir
+}
+
+func TestRoundTrip(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	store := NewStore(db)
+
+	user := cicada.User{
+		Name:  "justin",
+		Rooms: []string{"room1"},
+	}
+
+	id, err := store.Put(user)
+	if err != nil {
+		t.Fatal("error saving user", err)
+	}
+
+	u, err := store.Get(id)
+	if err != nil {
+		t.Fatal("error fetching user", err)
+	}
+
+	if u.Id != id {
+		t.Errorf("id mismatch, expected %s, got %s", id, u.Id)
+	}
+	if u.Name != user.Name {
+		t.Errorf("name mismatch, expected %s, got %s", user.Name, u.Name)
+	}
+	if !reflect.DeepEqual(u.Rooms, user.Rooms) {
+		t.Errorf("rooms mismatch, expected %v, got %v", user.Rooms, u.Rooms)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	store := NewStore(db)
+	id, err := store.Put(cicada.User{Name: "justin"})
+	if err != nil {
+		t.Fatal("error saving user", err)
+	}
+
+	err = store.Update(cicada.User{Id: id, Name: "jsx", Rooms: []string{}})
+	if err != nil {
+		t.Fatal("failed to update user", err)
+	}
+
+	u, err := store.Get(id)
+	if err != nil {
+		t.Fatal("error fetching user", err)
+	}
+	if u.Name != "jsx" {
+		t.Errorf("name was not updated, got %s", u.Name)
+	}
+}
+
+func TestUpdateBad(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	store := NewStore(db)
+	err := store.Update(cicada.User{Id: "asdfasdf", Name: "nobody"})
+	if !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected not found, got ", err)
+	}
+}
+
+func TestRooms(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	store := NewStore(db)
+	id, err := store.Put(cicada.User{Name: "justin"})
+	if err != nil {
+		t.Fatal("error saving user", err)
+	}
+
+	for _, r := range []string{"room1", "room2", "room1"} {
+		if err = store.AddRoom(id, r); err != nil {
+			t.Fatal("failed to add room", err)
+		}
+	}
+
+	u, _ := store.Get(id)
+	if !reflect.DeepEqual(u.Rooms, []string{"room1", "room2"}) {
+		t.Errorf("expected two distinct rooms, got %v", u.Rooms)
+	}
+
+	if err = store.RemoveRoom(id, "room1"); err != nil {
+		t.Fatal("failed to remove room", err)
+	}
+
+	u, _ = store.Get(id)
+	if !reflect.DeepEqual(u.Rooms, []string{"room2"}) {
+		t.Errorf("expected only room2, got %v", u.Rooms)
+	}
+}
+
+func TestGetBad(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	store := NewStore(db)
+	_, err := store.Get("asdfasdf")
+	if err == nil {
+		t.Error("expected error got none")
+	} else if !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected error not found, got ", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	store := NewStore(db)
+	id, err := store.Put(cicada.User{Name: "justin"})
+	if err != nil {
+		t.Fatal("error saving user", err)
+	}
+
+	if err = store.Delete(id); err != nil {
+		t.Fatal("failed to delete user", err)
+	}
+
+	_, err = store.Get(id)
+	if !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected not found after delete, got ", err)
+	}
+
+	err = store.Delete(id)
+	if !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected not found deleting twice, got ", err)
+	}
+}
+
+func TestGetByName(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	store := NewStore(db)
+	id, err := store.Put(cicada.User{Name: "justin"})
+	if err != nil {
+		t.Fatal("error saving user", err)
+	}
+
+	u, err := store.GetByName("justin")
+	if err != nil {
+		t.Fatal("error fetching user by name", err)
+	}
+	if u.Id != id {
+		t.Errorf("id mismatch, expected %s, got %s", id, u.Id)
+	}
+
+	_, err = store.GetByName("nobody")
+	if !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected not found, got ", err)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	db, dir := database()
+	defer db.Close()
+	defer os.Remove(filepath.Join(dir, "data.db"))
+
+	store := NewStore(db)
+	id, err := store.Put(cicada.User{Name: "justin"})
+	if err != nil {
+		t.Fatal("error saving user", err)
+	}
+
+	_, err = store.PasswordHash(id)
+	if !errors.Is(err, cicada.ErrorNotFound) {
+		t.Error("expected not found before a password is set, got ", err)
+	}
+
+	if err = store.SetPassword(id, []byte("hash")); err != nil {
+		t.Fatal("failed to set password", err)
+	}
+
+	// updating the user must not drop the password hash
+	if err = store.AddRoom(id, "room1"); err != nil {
+		t.Fatal("failed to add room", err)
+	}
+
+	hash, err := store.PasswordHash(id)
+	if err != nil {
+		t.Fatal("failed to get password hash", err)
+	}
+	if string(hash) != "hash" {
+		t.Errorf("expected hash, got %s", hash)
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"cicada"
+	"cicada/internal/server/store/chat"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// checkParent makes sure a reply is to a live message in the same room that
+// is not itself a reply, since threads are only one level deep.
+func (s *ChatService) checkParent(m cicada.ChatMessage) error {
+	parent, err := s.cs.Get(m.ParentId)
+	if errors.Is(err, cicada.ErrorNotFound) || (err == nil && parent.RoomId != m.RoomId) {
+		return fmt.Errorf("%w: no message %s in room %s", cicada.ErrorBadRequest, m.ParentId, m.RoomId)
+	} else if err != nil {
+		return err
+	}
+
+	if len(parent.ParentId) != 0 {
+		return fmt.Errorf("%w: message %s is a reply and can not start a thread", cicada.ErrorBadRequest, parent.Id)
+	}
+	if parent.Deleted {
+		return fmt.Errorf("%w: message %s has been deleted", cicada.ErrorConflict, parent.Id)
+	}
+	return nil
+}
+
+// Thread returns a message with a page of its replies, oldest first. The
+// position is a cursor from a previous page, and is empty for the first page.
+func (s *ChatService) Thread(userId, messageId, position string, limit int) (cicada.ThreadPage, error) {
+	if limit <= 0 {
+		limit = s.opts.HistoryPageSize
+	}
+	if limit > s.opts.MaxHistoryPageSize {
+		return cicada.ThreadPage{}, fmt.Errorf("%w: limit must be at most %d", cicada.ErrorBadRequest, s.opts.MaxHistoryPageSize)
+	}
+
+	parent, err := s.cs.Get(messageId)
+	if err != nil {
+		return cicada.ThreadPage{}, err
+	}
+
+	r, err := s.rs.Get(parent.RoomId)
+	if err != nil {
+		return cicada.ThreadPage{}, err
+	}
+
+	if !slices.Contains(r.Members, userId) {
+		return cicada.ThreadPage{}, fmt.Errorf("%w: %s is not a member of room %s", cicada.ErrorForbidden, userId, r.Id)
+	}
+
+	c := chat.Cursor{}
+	if len(position) != 0 {
+		if c, err = chat.ParseCursor(position); err != nil {
+			return cicada.ThreadPage{}, err
+		}
+	}
+
+	replies, err := s.cs.Replies(messageId, c, limit+1)
+	if err != nil {
+		return cicada.ThreadPage{}, err
+	}
+
+	page := cicada.ThreadPage{Parent: parent, Replies: replies}
+	if len(replies) > limit {
+		page.Replies = replies[:limit]
+		page.After = chat.CursorOf(replies[limit-1]).String()
+	}
+	return page, nil
+}
+
+// summariseThreads adds the reply counts of the messages in a page of history.
+func (s *ChatService) summariseThreads(page *cicada.HistoryPage) error {
+	ids := make([]string, len(page.Messages))
+	for i, m := range page.Messages {
+		ids[i] = m.Id
+	}
+
+	threads, err := s.cs.Threads(ids)
+	if err != nil {
+		return err
+	}
+	if len(threads) > 0 {
+		page.Threads = threads
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"cicada"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+type typingKey struct {
+	roomId string
+	userId string
+}
+
+// typingState is a user who is typing in a room. Nothing is stored, a user
+// who stops sending typing frames is announced as stopped once the timeout
+// passes.
+type typingState struct {
+	announced  time.Time
+	generation int
+	timer      *time.Timer
+}
+
+// typingTracker holds the typing states of all users, under its own lock so
+// it can be used while the service lock is held.
+type typingTracker struct {
+	m          sync.Mutex
+	generation int
+	states     map[typingKey]*typingState
+}
+
+// Typing relays a typing notification to the other members of a room. Clients
+// should repeat the notification while the user is typing. Repeats are only
+// relayed once per typing interval, and the user is announced as stopped
+// when no repeat arrives within the typing timeout.
+func (s *ChatService) Typing(userId, roomId string, typing bool) error {
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(r.Members, userId) {
+		return fmt.Errorf("%w: %s is not a member of room %s", cicada.ErrorForbidden, userId, roomId)
+	}
+
+	key := typingKey{roomId: roomId, userId: userId}
+	var relay bool
+	if typing {
+		relay = s.startTyping(key)
+	} else {
+		relay = s.stopTyping(key)
+	}
+
+	if relay {
+		return s.relayTyping(r.Members, key, typing)
+	}
+	return nil
+}
+
+// startTyping records that a user is typing and restarts their timeout. It
+// reports whether the notification should be relayed.
+func (s *ChatService) startTyping(key typingKey) bool {
+	t := &s.typing
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	now := time.Now()
+	state, ok := t.states[key]
+	if ok {
+		state.timer.Stop()
+	} else {
+		state = &typingState{}
+		t.states[key] = state
+	}
+
+	t.generation++
+	generation := t.generation
+	state.generation = generation
+	state.timer = time.AfterFunc(s.opts.TypingTimeout, func() {
+		s.expireTyping(key, generation)
+	})
+
+	if ok && now.Sub(state.announced) < s.opts.TypingInterval {
+		return false
+	}
+	state.announced = now
+	return true
+}
+
+// stopTyping forgets that a user is typing, and reports whether they were.
+func (s *ChatService) stopTyping(key typingKey) bool {
+	t := &s.typing
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	state, ok := t.states[key]
+	if ok {
+		state.timer.Stop()
+		delete(t.states, key)
+	}
+	return ok
+}
+
+// expireTyping announces that a user stopped typing when their timeout
+// passes without another typing notification.
+func (s *ChatService) expireTyping(key typingKey, generation int) {
+	t := &s.typing
+	t.m.Lock()
+	state, ok := t.states[key]
+	expired := ok && state.generation == generation
+	if expired {
+		delete(t.states, key)
+	}
+	t.m.Unlock()
+
+	if expired {
+		s.stoppedTyping(key)
+	}
+}
+
+// clearTyping announces that a user stopped typing in a room, if they were,
+// such as after they send a message or leave the room.
+func (s *ChatService) clearTyping(userId, roomId string) {
+	key := typingKey{roomId: roomId, userId: userId}
+	if s.stopTyping(key) {
+		s.stoppedTyping(key)
+	}
+}
+
+func (s *ChatService) stoppedTyping(key typingKey) {
+	r, err := s.rs.Get(key.roomId)
+	if err == nil {
+		err = s.relayTyping(r.Members, key, false)
+	}
+	if err != nil {
+		slog.Error("unable to relay typing", "room", key.roomId, "user", key.userId, "error", err)
+	}
+}
+
+func (s *ChatService) relayTyping(members []string, key typingKey, typing bool) error {
+	f, err := newFrame(cicada.FrameTyping, "", cicada.TypingPayload{
+		RoomId: key.roomId,
+		UserId: key.userId,
+		Typing: typing,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.broadcast(members, key.userId, f)
+	return nil
+}
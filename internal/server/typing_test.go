@@ -0,0 +1,80 @@
+package server
+
+import (
+	"cicada"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// typingIn takes the typing notifications queued for a connection, leaving
+// out any other frame.
+func typingIn(sub subscription) []bool {
+	var typing []bool
+	for {
+		select {
+		case b := <-sub.message:
+			f := cicada.Frame{}
+			p := cicada.TypingPayload{}
+			if json.Unmarshal(b, &f) == nil && f.Type == cicada.FrameTyping && json.Unmarshal(f.Payload, &p) == nil {
+				typing = append(typing, p.Typing)
+			}
+		default:
+			return typing
+		}
+	}
+}
+
+func TestTyping(t *testing.T) {
+	opts := DefaultOptions()
+	opts.TypingTimeout = 50 * time.Millisecond
+	opts.TypingInterval = time.Hour
+	s, done := service(opts)
+	defer done()
+
+	alice, bob, outsider := newUser(s, "alice"), newUser(s, "bob"), newUser(s, "outsider")
+	r := newRoom(t, s, alice, bob)
+	watcher := subscribe(s, bob)
+
+	typing := func(userId, roomId string, typing bool) func() error {
+		return func() error { return s.Typing(userId, roomId, typing) }
+	}
+	wait := func() error {
+		time.Sleep(4 * opts.TypingTimeout)
+		return nil
+	}
+	send := func() error {
+		_, err := s.SendMessage(cicada.ChatMessage{RoomId: r.Id, Sender: alice, Text: "done"})
+		return err
+	}
+
+	steps := []struct {
+		name    string
+		do      func() error
+		err     error
+		relayed []bool
+	}{
+		{"not a member", typing(outsider, r.Id, true), cicada.ErrorForbidden, nil},
+		{"missing room", typing(alice, "nowhere", true), cicada.ErrorNotFound, nil},
+		{"start", typing(alice, r.Id, true), nil, []bool{true}},
+		{"repeat within interval", typing(alice, r.Id, true), nil, nil},
+		{"stop", typing(alice, r.Id, false), nil, []bool{false}},
+		{"stop again", typing(alice, r.Id, false), nil, nil},
+		{"start again", typing(alice, r.Id, true), nil, []bool{true}},
+		{"timeout", wait, nil, []bool{false}},
+		{"start before sending", typing(alice, r.Id, true), nil, []bool{true}},
+		{"send", send, nil, []bool{false}},
+		{"no timeout after sending", wait, nil, nil},
+	}
+
+	for _, step := range steps {
+		if err := step.do(); !errors.Is(err, step.err) {
+			t.Fatalf("%s: expected %v, got %v", step.name, step.err, err)
+		}
+		if got := typingIn(watcher); !slices.Equal(got, step.relayed) {
+			t.Errorf("%s: expected %v to be relayed, got %v", step.name, step.relayed, got)
+		}
+	}
+}
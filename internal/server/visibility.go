@@ -0,0 +1,136 @@
+package server
+
+import (
+	"cicada"
+	"context"
+	"errors"
+	"fmt"
+	uuid "github.com/satori/go.uuid"
+	"slices"
+	"time"
+)
+
+// CreateInvite issues an invite to a room that the holder can use to join
+// it. Any member may invite to an invite-only room, while only members with
+// PermInvite may invite to a private room.
+func (s *ChatService) CreateInvite(ctx context.Context, userId, roomId string) (cicada.Invite, error) {
+	r, err := s.rs.Get(roomId)
+	if err != nil {
+		return cicada.Invite{}, err
+	}
+
+	if r.Direct {
+		return cicada.Invite{}, fmt.Errorf("%w: direct conversations can not be joined", cicada.ErrorBadRequest)
+	}
+	if err = s.canInvite(r, userId); err != nil {
+		return cicada.Invite{}, err
+	}
+
+	inv := cicada.Invite{
+		Token:     uuid.NewV4().String(),
+		RoomId:    roomId,
+		CreatedBy: userId,
+		Expires:   time.Now().Add(s.opts.InviteLifetime),
+	}
+	if err = s.rs.PutInvite(inv); err != nil {
+		return cicada.Invite{}, err
+	}
+	s.audit(ctx, cicada.AuditEvent{
+		Actor:   userId,
+		Action:  cicada.AuditInviteCreate,
+		RoomId:  roomId,
+		Changes: map[string]string{"invite": inv.Token, "expires": inv.Expires.UTC().Format(time.RFC3339)},
+	})
+	return inv, nil
+}
+
+// PublicRooms pages through the rooms anyone can join, optionally only those
+// whose name or description contains the search text.
+func (s *ChatService) PublicRooms(search string, from, limit int) (cicada.RoomPage, error) {
+	if limit <= 0 {
+		limit = s.opts.HistoryPageSize
+	}
+	if limit > s.opts.MaxHistoryPageSize {
+		return cicada.RoomPage{}, fmt.Errorf("%w: limit must be at most %d", cicada.ErrorBadRequest, s.opts.MaxHistoryPageSize)
+	}
+	if from < 0 {
+		return cicada.RoomPage{}, fmt.Errorf("%w: from must not be negative", cicada.ErrorBadRequest)
+	}
+
+	rooms, err := s.rs.GetAll(search, from, limit+1)
+	if err != nil {
+		return cicada.RoomPage{}, err
+	}
+
+	page := cicada.RoomPage{Rooms: rooms}
+	if len(rooms) > limit {
+		page.Rooms = rooms[:limit]
+		page.Next = from + limit
+	}
+	return page, nil
+}
+
+// checkAccess decides whether a user who is not a member may join a room,
+// given the invite token they hold, if any.
+func (s *ChatService) checkAccess(r cicada.Room, userId, token string) error {
+	if r.Direct {
+		if slices.Contains(r.Participants, userId) {
+			return nil
+		}
+		// do not reveal that the conversation exists
+		return cicada.ErrorNotFound
+	}
+
+	if visibility(r) == cicada.VisibilityPublic {
+		return nil
+	}
+
+	if len(token) == 0 {
+		return fmt.Errorf("%w: room %s can only be joined with an invite", cicada.ErrorForbidden, r.Id)
+	}
+
+	inv, err := s.rs.GetInvite(token)
+	if errors.Is(err, cicada.ErrorNotFound) || (err == nil && inv.RoomId != r.Id) {
+		return fmt.Errorf("%w: invalid invite for room %s", cicada.ErrorForbidden, r.Id)
+	} else if err != nil {
+		return err
+	}
+
+	if time.Now().After(inv.Expires) {
+		return fmt.Errorf("%w: invite has expired", cicada.ErrorForbidden)
+	}
+
+	// an invite stops working once its issuer could no longer issue it
+	if s.canInvite(r, inv.CreatedBy) != nil {
+		return fmt.Errorf("%w: invite has been withdrawn", cicada.ErrorForbidden)
+	}
+	return nil
+}
+
+// canInvite checks that a user may invite others to a room.
+func (s *ChatService) canInvite(r cicada.Room, userId string) error {
+	if visibility(r) == cicada.VisibilityPrivate {
+		return s.authorize(r, userId, PermInvite)
+	}
+	if len(roleOf(r, userId)) == 0 {
+		return fmt.Errorf("%w: %s is not a member of room %s", cicada.ErrorForbidden, userId, r.Id)
+	}
+	return nil
+}
+
+func validateVisibility(v string) error {
+	switch v {
+	case "", cicada.VisibilityPublic, cicada.VisibilityInvite, cicada.VisibilityPrivate:
+		return nil
+	}
+	return fmt.Errorf("%w: visibility must be %s, %s or %s", cicada.ErrorBadRequest, cicada.VisibilityPublic, cicada.VisibilityInvite, cicada.VisibilityPrivate)
+}
+
+// visibility returns a room's visibility, treating rooms created before
+// rooms had one as public.
+func visibility(r cicada.Room) string {
+	if len(r.Visibility) == 0 {
+		return cicada.VisibilityPublic
+	}
+	return r.Visibility
+}
@@ -12,4 +12,42 @@ type ChatMessage struct {
 	Sender string    `clover:"sender" json:"sender"`
 	Text   string    `clover:"text" json:"text"`
 	Images []Image   `clover:"images" json:"images"`
+	// ParentId is set on replies, to the message that started the thread.
+	// Replies are kept out of the room's main timeline.
+	ParentId string `clover:"parentId,omitempty" json:"parentId,omitempty"`
+	// EditedAt is set when the text was last changed, or when the message was
+	// deleted.
+	EditedAt *time.Time `clover:"editedAt,omitempty" json:"editedAt,omitempty"`
+	// Deleted marks a tombstone left in place of a deleted message. Its text
+	// and images are removed.
+	Deleted bool `clover:"deleted,omitempty" json:"deleted,omitempty"`
+	// Reactions maps each emoji to the users who reacted with it.
+	Reactions map[string][]string `clover:"reactions,omitempty" json:"reactions,omitempty"`
+	// ReactionCounts is how many users reacted with each emoji. It is kept in
+	// step with Reactions by the store.
+	ReactionCounts map[string]int `clover:"reactionCounts,omitempty" json:"reactionCounts,omitempty"`
+}
+
+// Thread summarises the replies to a message.
+type Thread struct {
+	ParentId  string    `json:"parentId"`
+	Replies   int       `json:"replies"`
+	LastReply time.Time `json:"lastReply"`
+}
+
+// ThreadPage is a slice of the replies to a message, oldest first. After is
+// an opaque cursor for the next page, set only when there are more replies.
+type ThreadPage struct {
+	Parent  ChatMessage   `json:"parent"`
+	Replies []ChatMessage `json:"replies"`
+	After   string        `json:"after,omitempty"`
+}
+
+// Revision is an earlier text of an edited message. Date is when that text
+// was written.
+type Revision struct {
+	MessageId string    `clover:"messageId" json:"messageId"`
+	RoomId    string    `clover:"roomId" json:"roomId"`
+	Text      string    `clover:"text" json:"text"`
+	Date      time.Time `clover:"date" json:"date"`
 }
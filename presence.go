@@ -0,0 +1,18 @@
+package cicada
+
+import "time"
+
+// Presence states of a user.
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+// Presence tells whether a user is connected, and since when. Since is zero
+// for users who have not connected since the server started.
+type Presence struct {
+	UserId string    `json:"userId"`
+	State  string    `json:"state"`
+	Since  time.Time `json:"since"`
+}
@@ -0,0 +1,20 @@
+package cicada
+
+import "time"
+
+// ReadReceipt marks the newest message a user has read in a room. Date is
+// the date of that message, not of when it was read.
+type ReadReceipt struct {
+	UserId    string    `clover:"userId" json:"userId"`
+	RoomId    string    `clover:"roomId" json:"roomId"`
+	MessageId string    `clover:"messageId" json:"messageId"`
+	Date      time.Time `clover:"date" json:"date"`
+}
+
+// RoomSummary is a room listed for one of its members, with the number of
+// messages from others that the member has not read.
+type RoomSummary struct {
+	Room
+	Unread   int          `json:"unread"`
+	LastRead *ReadReceipt `json:"lastRead,omitempty"`
+}
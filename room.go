@@ -1,9 +1,160 @@
 package cicada
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Room visibilities. A room without one is public.
+const (
+	// VisibilityPublic rooms are listed, and anyone may join them.
+	VisibilityPublic = "public"
+	// VisibilityInvite rooms are not listed, and are joined with an invite
+	// from any member.
+	VisibilityInvite = "invite"
+	// VisibilityPrivate rooms are not listed, and are joined with an invite
+	// from a moderator.
+	VisibilityPrivate = "private"
+)
+
 // Room indicates who can communicate with each other.
 type Room struct {
 	Id          string   `clover:"id" json:"id,omitempty"`
 	Name        string   `clover:"name" json:"name"`
 	Description string   `clover:"description" json:"description"`
 	Members     []string `clover:"members" json:"members,omitempty"`
+	// Visibility controls who can find and join the room.
+	Visibility string `clover:"visibility,omitempty" json:"visibility,omitempty"`
+	// Roles maps members to their role in the room. Members without an
+	// entry have the member role.
+	Roles map[string]string `clover:"roles" json:"roles,omitempty"`
+	// Direct marks a private conversation between two users. It is opened
+	// rather than created, has no owner and is never listed.
+	Direct bool `clover:"direct,omitempty" json:"direct,omitempty"`
+	// Participants are the two users of a direct conversation, whether or not
+	// they are currently members of it.
+	Participants []string `clover:"participants,omitempty" json:"participants,omitempty"`
+	// Retention limits how long the room's messages are kept, on top of the
+	// server's own limits.
+	Retention *Retention `clover:"retention" json:"retention,omitempty"`
+	// LegalHold keeps every message in the room, whatever the retention
+	// limits, and keeps the room itself when its last member leaves.
+	LegalHold bool `clover:"legalHold" json:"legalHold,omitempty"`
+}
+
+// Retention limits how long messages are kept. Messages older than MaxAge,
+// or older than the newest MaxMessages, are deleted. Zero values do not
+// limit.
+type Retention struct {
+	MaxAge      time.Duration `clover:"maxAge" json:"maxAge"`
+	MaxMessages int           `clover:"maxMessages" json:"maxMessages"`
+}
+
+// IsZero reports whether the retention limits nothing.
+func (r Retention) IsZero() bool {
+	return r.MaxAge == 0 && r.MaxMessages == 0
+}
+
+type retentionJson struct {
+	MaxAge      json.RawMessage `json:"maxAge,omitempty"`
+	MaxMessages int             `json:"maxMessages,omitempty"`
+}
+
+// MarshalJSON writes the maximum age as a duration such as "720h".
+func (r Retention) MarshalJSON() ([]byte, error) {
+	v := retentionJson{MaxMessages: r.MaxMessages}
+	if r.MaxAge != 0 {
+		v.MaxAge, _ = json.Marshal(r.MaxAge.String())
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON reads the maximum age as either a duration such as "720h"
+// or a number of nanoseconds, which is how it is stored.
+func (r *Retention) UnmarshalJSON(b []byte) error {
+	v := retentionJson{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	r.MaxMessages = v.MaxMessages
+	r.MaxAge = 0
+	if len(v.MaxAge) == 0 || string(v.MaxAge) == "null" {
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(v.MaxAge, &text); err == nil {
+		r.MaxAge, err = time.ParseDuration(text)
+		return err
+	}
+	ns, err := strconv.ParseInt(string(v.MaxAge), 10, 64)
+	if err != nil {
+		return fmt.Errorf("maxAge must be a duration: %w", err)
+	}
+	r.MaxAge = time.Duration(ns)
+	return nil
+}
+
+// Roles a member can have in a room. Every room except a direct conversation
+// has exactly one owner.
+const (
+	RoleOwner     = "owner"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+// Invite lets its holder join an invite-only or private room until it
+// expires, for as long as the member who issued it stays in the room.
+type Invite struct {
+	Token     string    `clover:"_id" json:"token"`
+	RoomId    string    `clover:"roomId" json:"roomId"`
+	CreatedBy string    `clover:"createdBy" json:"createdBy"`
+	Expires   time.Time `clover:"expires" json:"expires"`
+}
+
+// RoomPage is a page of the public room directory. Next is the offset of the
+// following page, set only when there are more rooms.
+type RoomPage struct {
+	Rooms []Room `json:"rooms"`
+	Next  int    `json:"next,omitempty"`
+}
+
+// Moderation actions that can be taken against a member of a room.
+const (
+	// SanctionKick removes a member, who may join again.
+	SanctionKick = "kick"
+	// SanctionBan removes a member and keeps them out until it expires or is
+	// revoked.
+	SanctionBan = "ban"
+	// SanctionMute lets a member read the room but not post to it until it
+	// expires or is revoked.
+	SanctionMute = "mute"
+)
+
+// Sanction records a moderation action taken against a user in a room.
+type Sanction struct {
+	Id     string    `clover:"_id" json:"id"`
+	RoomId string    `clover:"roomId" json:"roomId"`
+	UserId string    `clover:"userId" json:"userId"`
+	Action string    `clover:"action" json:"action"`
+	By     string    `clover:"by" json:"by"`
+	Reason string    `clover:"reason,omitempty" json:"reason,omitempty"`
+	Date   time.Time `clover:"date" json:"date"`
+	// Expires ends a ban or mute. Without it, the sanction lasts until it
+	// is revoked.
+	Expires   *time.Time `clover:"expires,omitempty" json:"expires,omitempty"`
+	Revoked   *time.Time `clover:"revoked,omitempty" json:"revoked,omitempty"`
+	RevokedBy string     `clover:"revokedBy,omitempty" json:"revokedBy,omitempty"`
+}
+
+// Active reports whether a ban or mute is in force at the given time. A kick
+// is over as soon as it is taken.
+func (s Sanction) Active(now time.Time) bool {
+	if s.Action == SanctionKick || s.Revoked != nil {
+		return false
+	}
+	return s.Expires == nil || now.Before(*s.Expires)
 }
@@ -0,0 +1,15 @@
+package cicada
+
+// SearchResult is a message that matched a search, with a short excerpt of
+// its text around the matches.
+type SearchResult struct {
+	Message ChatMessage   `json:"message"`
+	Snippet []SnippetPart `json:"snippet"`
+}
+
+// SnippetPart is a run of text in a snippet. Match is set on runs that
+// matched a search term, so clients can highlight them.
+type SnippetPart struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}